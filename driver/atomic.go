@@ -0,0 +1,229 @@
+package driver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dataLink points to the current revision of a folder volume
+const dataLink = "..data"
+
+// writeFileAtomic writes data to a temp file next to p and renames it into
+// place, so readers see either the old or the new content
+func writeFileAtomic(p string, data []byte, mode os.FileMode) error {
+	return writeFileAtomicAs(p, data, mode, -1, -1)
+}
+
+// writeFileAtomicAs is writeFileAtomic with the owner set before the file
+// is renamed into place. An uid or gid of -1 is kept
+func writeFileAtomicAs(p string, data []byte, mode os.FileMode, uid int, gid int) error {
+	f, err := ioutil.TempFile(filepath.Dir(p), "."+filepath.Base(p)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	tmp := f.Name()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Chmod(tmp, mode); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if uid >= 0 || gid >= 0 {
+		if err := os.Chown(tmp, uid, gid); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+
+	if err := os.Rename(tmp, p); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+// writeFileInPlace overwrites the content of p and keeps its inode, so a
+// bind mount of the file sees the update. Readers may see a partial write,
+// a missing file is created atomically
+func writeFileInPlace(p string, data []byte, mode os.FileMode, uid int, gid int) error {
+	f, err := os.OpenFile(p, os.O_WRONLY, 0)
+	if os.IsNotExist(err) {
+		return writeFileAtomicAs(p, data, mode, uid, gid)
+	} else if err != nil {
+		return err
+	}
+
+	// write over the former content before cutting it, so the file is never
+	// empty in between
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Truncate(int64(len(data))); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(p, mode); err != nil {
+		return err
+	}
+
+	if uid >= 0 || gid >= 0 {
+		return os.Chown(p, uid, gid)
+	}
+
+	return nil
+}
+
+// stageFolder creates an empty revision directory below root
+func stageFolder(root string) (string, error) {
+	if err := os.MkdirAll(root, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	stage, err := ioutil.TempDir(root, "..rev")
+	if err != nil {
+		return "", err
+	}
+
+	return stage, os.Chmod(stage, 0755)
+}
+
+// swapFolder makes a staged revision the current content of root. The
+// ..data link is switched in a single rename and every top level entry of
+// root links through it, so readers never see a partial revision. Entries
+// that are or hold one of the nested roots of other volumes are kept
+func swapFolder(root string, stage string, nested []string) error {
+	rev := filepath.Base(stage)
+
+	if err := replaceLink(rev, filepath.Join(root, dataLink)); err != nil {
+		return err
+	}
+
+	entries, err := ioutil.ReadDir(stage)
+	if err != nil {
+		return err
+	}
+
+	current := map[string]bool{}
+	for _, e := range entries {
+		current[e.Name()] = true
+
+		if holdsRoot(filepath.Join(root, e.Name()), nested) {
+			continue
+		}
+
+		if err := replaceLink(filepath.Join(dataLink, e.Name()), filepath.Join(root, e.Name())); err != nil {
+			return err
+		}
+	}
+
+	// drop former revisions and entries that are gone
+	existing, err := ioutil.ReadDir(root)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range existing {
+		name := e.Name()
+		if name == dataLink || name == rev || current[name] || holdsRoot(filepath.Join(root, name), nested) {
+			continue
+		}
+
+		if err := os.RemoveAll(filepath.Join(root, name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// holdsRoot tells if p is one of roots or a folder above one
+func holdsRoot(p string, roots []string) bool {
+	for _, r := range roots {
+		if r == p || isBelow(p, r) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// prunedPaths lists the paths of the current revision of root that are
+// missing in stage. A removed folder is listed without its children
+func prunedPaths(root string, stage string) ([]string, error) {
+	rev, err := os.Readlink(filepath.Join(root, dataLink))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	current := filepath.Join(root, rev)
+
+	pruned := []string{}
+	err = filepath.Walk(current, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(current, p)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		if _, err := os.Lstat(filepath.Join(stage, rel)); os.IsNotExist(err) {
+			pruned = append(pruned, rel)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+
+		return nil
+	})
+
+	return pruned, err
+}
+
+// replaceLink atomically points p to target. Plain files and directories
+// left over by former versions are removed first
+func replaceLink(target string, p string) error {
+	if stat, err := os.Lstat(p); err == nil && stat.Mode()&os.ModeSymlink == 0 {
+		if err := os.RemoveAll(p); err != nil {
+			return err
+		}
+	}
+
+	tmp := filepath.Join(filepath.Dir(p), "..tmp"+strings.TrimPrefix(filepath.Base(p), ".."))
+	os.Remove(tmp)
+
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, p); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
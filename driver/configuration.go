@@ -3,33 +3,67 @@ package driver
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"sort"
 	"strings"
 )
 
+// supportedBackends lists the backend types NewStore is able to create
+var supportedBackends = []string{"etcd", "etcd3", "consul", "boltdb", "file", "git", "vault", "overlay"}
+
 // Configuration
 type Configuration struct {
-	Driver    DriverSettings    `json:"driver"`
-	Backend   BackendSettings   `json:"backend"`
-	Generator GeneratorSettings `json:"generator,omitempty"`
+	Driver    DriverSettings             `json:"driver"`
+	Backend   BackendSettings            `json:"backend"`
+	Backends  map[string]BackendSettings `json:"backends,omitempty"`
+	Generator GeneratorSettings          `json:"generator,omitempty"`
+	Cache     CacheSettings              `json:"cache,omitempty"`
 }
 
 // DriverSettings
 type DriverSettings struct {
 	RootPath string `json:"rootpath"`
+	Strict   bool   `json:"strict,omitempty"`
 }
 
 // BackendSettings holds the settings for the libkv backend
 type BackendSettings struct {
-	Type      string `json:"type"`
-	Endpoints string `json:"endpoints"`
-	Timeout   int    `json:"timeout"`
+	Type         string            `json:"type"`
+	Endpoints    string            `json:"endpoints"`
+	Timeout      int               `json:"timeout"`
+	PollInterval int               `json:"pollinterval,omitempty"`
+	Path         string            `json:"path,omitempty"`
+	Ref          string            `json:"ref,omitempty"`
+	Bucket       string            `json:"bucket,omitempty"`
+	Vault        VaultSettings     `json:"vault,omitempty"`
+	Layers       []BackendSettings `json:"layers,omitempty"`
+}
+
+// VaultSettings holds the settings for the vault kv v2 backend
+type VaultSettings struct {
+	Mount    string `json:"mount,omitempty"`
+	Field    string `json:"field,omitempty"`
+	Token    string `json:"token,omitempty"`
+	RoleID   string `json:"roleid,omitempty"`
+	SecretID string `json:"secretid,omitempty"`
+}
+
+// CacheSettings holds the settings for the last known good cache of remote
+// backends. The cache is disabled without a path, vault secrets are only
+// cached with Secrets set
+type CacheSettings struct {
+	Path    string `json:"path,omitempty"`
+	MaxAge  int    `json:"maxage,omitempty"`
+	Secrets bool   `json:"secrets,omitempty"`
 }
 
-// GeneratorSettings
+// GeneratorSettings. Env names the environment variables of the plugin
+// that templates can read
 type GeneratorSettings struct {
-	Disabled bool `json:"disabled,omitempty"`
+	Disabled bool     `json:"disabled,omitempty"`
+	Env      []string `json:"env,omitempty"`
 }
 
 // LoadFromString loads a configuration from json string
@@ -65,20 +99,116 @@ func (c *Configuration) CheckIntegrity() (bool, []error) {
 		errorList = append(errorList, errors.New("driver.rootpath directory did not exist"))
 	}
 
-	// check backend type
-	if c.Backend.Type != "etcd" {
-		errorList = append(errorList, errors.New("backend.type only supports 'etcd' at the moment"))
+	// check backend. Profiles and layers inherit an unset poll interval
+	errorList = append(errorList, checkBackend("backend", &c.Backend)...)
+	if c.Backend.PollInterval == 0 {
+		errorList = append(errorList, errors.New("backend.pollinterval has to be a positive number of seconds"))
+	}
+
+	// check backend profiles
+	names := []string{}
+	for name := range c.Backends {
+		names = append(names, name)
 	}
+	sort.Strings(names)
 
-	// check backend endpoints
-	if c.Backend.Endpoints == "" {
-		errorList = append(errorList, errors.New("backend.endpoints is a neccessary field"))
+	for _, name := range names {
+		b := c.Backends[name]
+		errorList = append(errorList, checkBackend("backends."+name, &b)...)
 	}
 
 	res := len(errorList) == 0
 	return res, errorList
 }
 
+// checkBackend tests the integrity of a backend and its layers
+func checkBackend(name string, b *BackendSettings) []error {
+	errorList := []error{}
+
+	// check backend type
+	if !isSupportedBackend(b.Type) {
+		errorList = append(errorList, fmt.Errorf("%s.type only supports '%s' at the moment", name, strings.Join(supportedBackends, "', '")))
+	}
+
+	// check backend endpoints, the local path or the layers
+	switch b.Type {
+	case "file", "git":
+		if stat, err := os.Stat(b.Path); err != nil || stat.IsDir() == false {
+			errorList = append(errorList, fmt.Errorf("%s.path directory did not exist", name))
+		}
+	case "boltdb":
+		if b.Path == "" {
+			errorList = append(errorList, fmt.Errorf("%s.path is a neccessary field", name))
+		}
+	case "overlay":
+		if len(b.Layers) == 0 {
+			errorList = append(errorList, fmt.Errorf("%s.layers needs at least one backend", name))
+		}
+
+		for i := range b.Layers {
+			errorList = append(errorList, checkBackend(fmt.Sprintf("%s.layers[%d]", name, i), &b.Layers[i])...)
+		}
+	default:
+		if b.Endpoints == "" {
+			errorList = append(errorList, fmt.Errorf("%s.endpoints is a neccessary field", name))
+		}
+	}
+
+	// check poll interval, a non positive one polls in a tight loop
+	if b.PollInterval < 0 {
+		errorList = append(errorList, fmt.Errorf("%s.pollinterval has to be a positive number of seconds", name))
+	}
+
+	// check vault auth
+	if b.Type == "vault" && b.Vault.Token == "" && (b.Vault.RoleID == "" || b.Vault.SecretID == "") {
+		errorList = append(errorList, fmt.Errorf("%s.vault needs a token or a roleid and secretid", name))
+	}
+
+	return errorList
+}
+
+// isSupportedBackend checks if the backend type is known
+func isSupportedBackend(t string) bool {
+	for _, b := range supportedBackends {
+		if b == t {
+			return true
+		}
+	}
+
+	return false
+}
+
+// BackendConfiguration returns a copy of the configuration using a backend profile
+func (c *Configuration) BackendConfiguration(name string) (*Configuration, error) {
+	b, ok := c.Backends[name]
+	if !ok {
+		return nil, errors.New("Unknown backend " + name)
+	}
+
+	return c.withBackend(b), nil
+}
+
+// withBackend returns a copy of the configuration using another backend.
+// Unset defaults are inherited
+func (c *Configuration) withBackend(b BackendSettings) *Configuration {
+	bc := *c
+	bc.Backend = b
+
+	if bc.Backend.Timeout == 0 {
+		bc.Backend.Timeout = c.Backend.Timeout
+	}
+
+	if bc.Backend.PollInterval == 0 {
+		bc.Backend.PollInterval = c.Backend.PollInterval
+	}
+
+	if len(bc.Backend.Bucket) == 0 {
+		bc.Backend.Bucket = c.Backend.Bucket
+	}
+
+	return &bc
+}
+
 // GetBackendEndpointList returns the endpoints as list
 func (c *Configuration) GetBackendEndpointList() []string {
 	return strings.Split(strings.Replace(c.Backend.Endpoints, " ", "", -1), ",")
@@ -89,5 +219,7 @@ func NewConfiguration() *Configuration {
 	c := &Configuration{}
 	c.Backend.Type = "etcd"
 	c.Backend.Timeout = 30
+	c.Backend.PollInterval = 10
+	c.Backend.Bucket = "confvol"
 	return c
 }
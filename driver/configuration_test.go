@@ -28,6 +28,7 @@ var _ = Describe("Configuration", func() {
 			conf := NewConfiguration()
 			Expect(conf.Backend.Type).Should(Equal("etcd"))
 			Expect(conf.Backend.Timeout).Should(Equal(30))
+			Expect(conf.Backend.Bucket).Should(Equal("confvol"))
 		})
 	})
 
@@ -92,17 +93,166 @@ var _ = Describe("Configuration", func() {
 
 		It("can verify configuration integrity", func() {
 			conf := NewConfiguration()
-			conf.Backend.Type = "consul"
+			conf.Backend.Type = "zookeeper"
 
 			integer, errList := conf.CheckIntegrity()
 			Expect(integer).To(Equal(false))
 			Expect(len(errList)).To(Equal(3))
 
 			Expect(errList[0].Error()).To(Equal("driver.rootpath directory did not exist"))
-			Expect(errList[1].Error()).To(Equal("backend.type only supports 'etcd' at the moment"))
+			Expect(errList[1].Error()).To(Equal("backend.type only supports 'etcd', 'etcd3', 'consul', 'boltdb', 'file', 'git', 'vault', 'overlay' at the moment"))
 			Expect(errList[2].Error()).To(Equal("backend.endpoints is a neccessary field"))
 		})
 
+		It("accepts consul as backend type", func() {
+			conf := NewConfiguration()
+			conf.Driver.RootPath = os.TempDir()
+			conf.Backend.Type = "consul"
+			conf.Backend.Endpoints = "127.0.0.1:8500"
+
+			integer, errList := conf.CheckIntegrity()
+			Expect(errList).To(BeEmpty())
+			Expect(integer).To(Equal(true))
+		})
+
+		It("accepts etcd3 as backend type", func() {
+			conf := NewConfiguration()
+			conf.Driver.RootPath = os.TempDir()
+			conf.Backend.Type = "etcd3"
+			conf.Backend.Endpoints = "127.0.0.1:2379"
+
+			integer, errList := conf.CheckIntegrity()
+			Expect(errList).To(BeEmpty())
+			Expect(integer).To(Equal(true))
+		})
+
+		It("accepts file as backend type without endpoints", func() {
+			conf := NewConfiguration()
+			conf.Driver.RootPath = os.TempDir()
+			conf.Backend.Type = "file"
+			conf.Backend.Path = "../examples/etcd_datatree"
+
+			integer, errList := conf.CheckIntegrity()
+			Expect(errList).To(BeEmpty())
+			Expect(integer).To(Equal(true))
+		})
+
+		It("checks the layers of an overlay backend", func() {
+			conf := NewConfiguration()
+			conf.Driver.RootPath = os.TempDir()
+			conf.Backend.Type = "overlay"
+
+			integer, errList := conf.CheckIntegrity()
+			Expect(integer).To(Equal(false))
+			Expect(len(errList)).To(Equal(1))
+			Expect(errList[0].Error()).To(Equal("backend.layers needs at least one backend"))
+
+			conf.Backend.Layers = []BackendSettings{
+				{Type: "file", Path: "../examples/etcd_datatree"},
+				{Type: "consul"},
+			}
+
+			integer, errList = conf.CheckIntegrity()
+			Expect(integer).To(Equal(false))
+			Expect(len(errList)).To(Equal(1))
+			Expect(errList[0].Error()).To(Equal("backend.layers[1].endpoints is a neccessary field"))
+		})
+
+		It("checks backend profiles", func() {
+			conf := NewConfiguration()
+			conf.Driver.RootPath = os.TempDir()
+			conf.Backend.Endpoints = "127.0.0.1:2379"
+			conf.Backends = map[string]BackendSettings{
+				"shared": {Type: "consul"},
+				"local":  {Type: "file", Path: "../examples/etcd_datatree"},
+			}
+
+			integer, errList := conf.CheckIntegrity()
+			Expect(integer).To(Equal(false))
+			Expect(len(errList)).To(Equal(1))
+			Expect(errList[0].Error()).To(Equal("backends.shared.endpoints is a neccessary field"))
+		})
+
+		It("returns the configuration of a backend profile", func() {
+			conf := NewConfiguration()
+			conf.Backends = map[string]BackendSettings{
+				"local": {Type: "file", Path: "../examples/etcd_datatree"},
+			}
+
+			bc, err := conf.BackendConfiguration("local")
+			Expect(err).To(BeNil())
+			Expect(bc.Backend.Type).To(Equal("file"))
+			Expect(bc.Backend.Timeout).To(Equal(30))
+			Expect(conf.Backend.Type).To(Equal("etcd"))
+
+			_, err = conf.BackendConfiguration("prod")
+			Expect(err).To(MatchError("Unknown backend prod"))
+		})
+
+		It("requires vault credentials", func() {
+			conf := NewConfiguration()
+			conf.Driver.RootPath = os.TempDir()
+			conf.Backend.Type = "vault"
+			conf.Backend.Endpoints = "127.0.0.1:8200"
+			conf.Backend.Vault.RoleID = "role"
+
+			integer, errList := conf.CheckIntegrity()
+			Expect(integer).To(Equal(false))
+			Expect(len(errList)).To(Equal(1))
+			Expect(errList[0].Error()).To(Equal("backend.vault needs a token or a roleid and secretid"))
+
+			conf.Backend.Vault.SecretID = "secret"
+			integer, errList = conf.CheckIntegrity()
+			Expect(errList).To(BeEmpty())
+			Expect(integer).To(Equal(true))
+		})
+
+		It("requires a path for the boltdb backend", func() {
+			conf := NewConfiguration()
+			conf.Driver.RootPath = os.TempDir()
+			conf.Backend.Type = "boltdb"
+
+			integer, errList := conf.CheckIntegrity()
+			Expect(integer).To(Equal(false))
+			Expect(len(errList)).To(Equal(1))
+			Expect(errList[0].Error()).To(Equal("backend.path is a neccessary field"))
+		})
+
+		It("requires a positive poll interval", func() {
+			conf := NewConfiguration()
+			conf.Driver.RootPath = os.TempDir()
+			conf.Backend.Endpoints = "127.0.0.1:2379"
+			conf.Backend.PollInterval = 0
+			conf.Backends = map[string]BackendSettings{
+				"local": {Type: "file", Path: os.TempDir(), PollInterval: -1},
+				"fast":  {Type: "file", Path: os.TempDir(), PollInterval: 1},
+			}
+
+			integer, errList := conf.CheckIntegrity()
+			Expect(integer).To(Equal(false))
+			Expect(len(errList)).To(Equal(2))
+			Expect(errList[0].Error()).To(Equal("backend.pollinterval has to be a positive number of seconds"))
+			Expect(errList[1].Error()).To(Equal("backends.local.pollinterval has to be a positive number of seconds"))
+
+			conf.Backend.PollInterval = -5
+			integer, errList = conf.CheckIntegrity()
+			Expect(integer).To(Equal(false))
+			Expect(len(errList)).To(Equal(2))
+			Expect(errList[0].Error()).To(Equal("backend.pollinterval has to be a positive number of seconds"))
+		})
+
+		It("requires an existing path for the file backend", func() {
+			conf := NewConfiguration()
+			conf.Driver.RootPath = os.TempDir()
+			conf.Backend.Type = "file"
+			conf.Backend.Path = "do/not/exist"
+
+			integer, errList := conf.CheckIntegrity()
+			Expect(integer).To(Equal(false))
+			Expect(len(errList)).To(Equal(1))
+			Expect(errList[0].Error()).To(Equal("backend.path directory did not exist"))
+		})
+
 		It("can verify configuration integrity", func() {
 			conf := NewConfiguration()
 			conf.Backend.Endpoints = " 10.0.0.1,    10.0.0.2"
@@ -2,9 +2,9 @@ package driver
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
-	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -12,95 +12,270 @@ import (
 
 	"github.com/docker/go-plugins-helpers/volume"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
 )
 
 // VolumeMount
 type VolumeMount struct {
-	Root              string
-	Relative          string
-	ReferenceCounter  int
-	Mode              int
-	TemplateGenerator bool
+	Root              string            `json:"root"`
+	Relative          string            `json:"relative"`
+	ReferenceCounter  int               `json:"refcount"`
+	Mode              int               `json:"mode,omitempty"`
+	DirMode           int               `json:"dirmode,omitempty"`
+	UID               int               `json:"uid"`
+	GID               int               `json:"gid"`
+	TemplateGenerator bool              `json:"tmpl,omitempty"`
+	TemplateSuffix    string            `json:"tmplsuffix,omitempty"`
+	TemplateStrict    bool              `json:"tmplstrict,omitempty"`
+	Revision          string            `json:"ref,omitempty"`
+	Type              string            `json:"type,omitempty"`
+	Strict            bool              `json:"strict,omitempty"`
+	Backend           string            `json:"backend,omitempty"`
+	Options           map[string]string `json:"options,omitempty"`
+	MountID           string            `json:"mountid,omitempty"`
+	name              string
+	m                 *sync.Mutex
+	syncM             *sync.Mutex
+	store             Store
+	watchKeys         []watchKey
+	stopWatch         chan struct{}
+	setupErr          error
 }
 
-// ConfigVolume driver
+// ConfigVolume driver. m guards the registry of volumes and the stores. Each
+// volume has its own mutex for its mount state and another one serializing
+// its syncs, so a slow store only blocks the volumes that wait for it
 type ConfigVolume struct {
-	logger     *logrus.Logger
-	volumes    map[string]*VolumeMount
-	m          *sync.Mutex
-	mountPoint string
-	store      Store
+	logger        *logrus.Logger
+	volumes       map[string]*VolumeMount
+	m             *sync.Mutex
+	stateM        *sync.Mutex
+	syncs         *singleflight.Group
+	mountPoint    string
+	store         Store
+	stores        map[string]Store
+	configuration *Configuration
 }
 
-// synchronize a list of kv entries to the fs
-func (v *ConfigVolume) syncFolder(kvEntries []*StoreKVPair, basePath string, relativePath string) {
-	s := v.store
+// storeOf returns the store a volume syncs from
+func (v *ConfigVolume) storeOf(vm *VolumeMount) Store {
+	if vm.store != nil {
+		return vm.store
+	}
+
+	return v.store
+}
+
+// namedStore returns the store of a backend profile, created on first use.
+// The store is set up without holding the registry lock, concurrent calls
+// share a single setup
+func (v *ConfigVolume) namedStore(name string) (Store, error) {
+	v.m.Lock()
+	s, ok := v.stores[name]
+	v.m.Unlock()
+
+	if ok {
+		return s, nil
+	}
+
+	// volume names can't use the .. prefix, so the key is never taken by a sync
+	created, err, _ := v.syncs.Do("..store/"+name, func() (interface{}, error) {
+		c, err := v.configuration.BackendConfiguration(name)
+		if err != nil {
+			return nil, err
+		}
+
+		s, err := NewStore(c, v.logger)
+		if err != nil {
+			return nil, err
+		}
+
+		v.m.Lock()
+		defer v.m.Unlock()
+
+		if known, ok := v.stores[name]; ok {
+			return known, nil
+		}
+
+		v.stores[name] = s
+		return s, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return created.(Store), nil
+}
+
+// synchronize all kv entries below the key of a volume to the fs. Nested
+// keys become folders below basePath. Templates are rendered with tmpl, a
+// failing template is reported by key
+func (v *ConfigVolume) syncFolder(vm *VolumeMount, kvEntries []*StoreKVPair, basePath string, tmpl *ConfTemplate, ctx *TemplateContext) error {
+	key := vm.Relative
+	failed := []string{}
 
 	for _, pair := range kvEntries {
+		rel, ok := relativeKey(key, pair.Key)
+		if !ok {
+			continue
+		}
+
+		// keys must neither leave the volume nor replace its links
+		dstPath, err := SafeJoin(basePath, rel)
+		if err != nil {
+			return errors.New("Key " + pair.Key + " leaves the volume " + key)
+		}
+
+		if strings.HasPrefix(rel, "..") {
+			return errors.New("Key " + pair.Key + " uses the reserved prefix ..")
+		}
+
 		v.logger.Debugf("Sync source %s", pair.Key)
 
-		fileName := pair.Key[len(relativePath):]
-		dstPath := path.Join(basePath, fileName)
+		if pair.IsDir {
+			if err := vm.mkdirAll(dstPath); err != nil {
+				return err
+			}
+			continue
+		}
 
-		entryList, _ := s.List(pair.Key)
-		entryData, _ := s.Get(pair.Key)
+		if err := vm.mkdirAll(filepath.Dir(dstPath)); err != nil {
+			return err
+		}
 
-		//TODO find a better way to distinguish files from folders (EC empty folder or empty file)
-		isFolder := len(entryData.Value) == 0
+		data := pair.Value
+		if out, ok := vm.templateName(dstPath); ok {
+			fileCtx := *ctx
+			fileCtx.Template = pair.Key
 
-		if isFolder == true {
-			os.MkdirAll(dstPath, os.ModePerm)
-			v.syncFolder(entryList, dstPath, pair.Key)
-		} else {
-			if err := ioutil.WriteFile(dstPath, entryData.Value, 0644); err != nil {
-				v.logger.Error(err)
+			tmplOutput, err := tmpl.Parse(string(data), &fileCtx)
+			if err != nil {
+				v.logger.Errorf("Failed to render %s: %s", pair.Key, err)
+				failed = append(failed, pair.Key+": "+err.Error())
 			}
+
+			dstPath = out
+			data = []byte(tmplOutput)
+		}
+
+		if err := ioutil.WriteFile(dstPath, data, vm.fileMode()); err != nil {
+			return err
 		}
+
+		if err := vm.own(dstPath, vm.fileMode()); err != nil {
+			return err
+		}
+	}
+
+	// keep the former revision
+	if len(failed) > 0 && vm.Strict {
+		return errors.New("Failed to render " + strings.Join(failed, ", "))
 	}
+
+	return nil
 }
 
-// sync mount point. Folder mounts MUST end with a slash
+// sync mount point. Folder mounts end with a slash, are set by the type
+// option or are marked as folder by the store. The sync mutex of the volume
+// has to be held
 func (v *ConfigVolume) syncMountPoint(vm *VolumeMount) error {
-	s := v.store
+	s := v.storeOf(vm)
 
-	syncFolder := strings.HasSuffix(vm.Relative, "/")
+	var entry *StoreKVPair
+	syncFolder := isFolderVolume(vm)
+
+	if !syncFolder {
+		var err error
+		entry, err = s.Get(vm.Relative)
+		if err != nil {
+			v.logger.Error(err)
+			return err
+		}
+
+		syncFolder = entry.IsDir && vm.Type != "file"
+	}
+
+	tmpl := NewTemplate(s)
+	tmpl.Strict = vm.TemplateStrict
+	keys := []watchKey{{key: vm.Relative, tree: syncFolder}}
+	defer func() {
+		// re-render when a key used by a template changes
+		deps, folders := tmpl.Dependencies()
+		for _, k := range deps {
+			keys = append(keys, watchKey{key: k})
+		}
+		for _, k := range folders {
+			keys = append(keys, watchKey{key: k, tree: true})
+		}
+
+		vm.m.Lock()
+		vm.watchKeys = keys
+		vm.m.Unlock()
+	}()
 
 	if syncFolder == true {
-		entries, err := s.List(vm.Relative)
+		entries, err := listTree(s, vm.Relative)
 
 		if err != nil {
 			v.logger.Error(err)
 			return err
 		}
 
-		os.MkdirAll(vm.Root, os.ModePerm)
-		v.syncFolder(entries, vm.Root, vm.Relative)
-	} else {
-		entry, err := s.Get(vm.Relative)
+		// build the new revision aside and swap it in when complete
+		stage, err := stageFolder(vm.Root)
+		if err == nil {
+			err = vm.own(stage, vm.dirMode())
+		}
+		if err == nil {
+			err = vm.own(vm.Root, vm.dirMode())
+		}
 		if err != nil {
 			v.logger.Error(err)
+			os.RemoveAll(stage)
 			return err
 		}
 
-		os.MkdirAll(path.Dir(vm.Root), os.ModePerm)
+		if err := v.syncFolder(vm, entries, stage, tmpl, v.templateContext(vm)); err != nil {
+			v.logger.Errorf("Keep the current revision of %s: %s", vm.Relative, err)
+			os.RemoveAll(stage)
+			return err
+		}
 
-		mode := 0644
-		if vm.Mode > 0 {
-			mode = vm.Mode
+		// keys deleted from the store vanish with the former revision
+		pruned, err := prunedPaths(vm.Root, stage)
+		if err != nil {
+			v.logger.Error(err)
+		}
+		for _, p := range pruned {
+			v.logger.Infof("Prune %s from volume %s", p, vm.Relative)
 		}
 
-		data := entry.Value
-		if vm.TemplateGenerator {
-			tmpl := NewTemplate(v.store)
-			tmplOutput, err := tmpl.Parse(string(data), nil)
+		if err := swapFolder(vm.Root, stage, v.nestedRoots(vm)); err != nil {
+			v.logger.Error(err)
+			return err
+		}
+	} else {
+		if err := vm.mkdirAll(filepath.Dir(vm.Root)); err != nil {
+			v.logger.Error(err)
+			return err
+		}
 
+		data := entry.Value
+		if _, ok := vm.templateName(vm.Relative); ok {
+			tmplOutput, err := tmpl.Parse(string(data), v.templateContext(vm))
 			if err != nil {
 				v.logger.Error(err)
+
+				// keep the former output
+				if vm.Strict {
+					return err
+				}
 			}
 			data = []byte(tmplOutput)
 		}
 
-		if err := ioutil.WriteFile(vm.Root, data, os.FileMode(mode)); err != nil {
+		// containers bind mount the file itself, a rename would hide the update
+		if err := writeFileInPlace(vm.Root, data, vm.fileMode(), vm.UID, vm.GID); err != nil {
 			v.logger.Error(err)
 			return err
 		}
@@ -109,48 +284,316 @@ func (v *ConfigVolume) syncMountPoint(vm *VolumeMount) error {
 	return nil
 }
 
+// templateContext collects the data templates of a volume are rendered with
+func (v *ConfigVolume) templateContext(vm *VolumeMount) *TemplateContext {
+	hostname, err := os.Hostname()
+	if err != nil {
+		v.logger.Error(err)
+	}
+
+	env := map[string]string{}
+	for _, name := range v.configuration.Generator.Env {
+		if value, ok := os.LookupEnv(name); ok {
+			env[name] = value
+		}
+	}
+
+	vm.m.Lock()
+	mountID := vm.MountID
+	vm.m.Unlock()
+
+	return &TemplateContext{
+		Volume:   vm.name,
+		Key:      vm.Relative,
+		Template: vm.Relative,
+		Options:  vm.Options,
+		Hostname: hostname,
+		Env:      env,
+		MountID:  mountID,
+	}
+}
+
+// templateName tells if the file p of a volume is a template and returns
+// the path of its output. With a template suffix only files ending with it
+// are templates, and the suffix is stripped from the output
+func (vm *VolumeMount) templateName(p string) (string, bool) {
+	if len(vm.TemplateSuffix) == 0 {
+		return p, vm.TemplateGenerator
+	}
+
+	base := filepath.Base(p)
+	name := strings.TrimSuffix(base, vm.TemplateSuffix)
+	if name == base || name == "" || name == "." || name == ".." {
+		return p, false
+	}
+
+	return filepath.Join(filepath.Dir(p), name), true
+}
+
+// fileMode returns the mode of the files of a volume
+func (vm *VolumeMount) fileMode() os.FileMode {
+	if vm.Mode > 0 {
+		return os.FileMode(vm.Mode)
+	}
+
+	return 0644
+}
+
+// dirMode returns the mode of the folders of a volume
+func (vm *VolumeMount) dirMode() os.FileMode {
+	if vm.DirMode > 0 {
+		return os.FileMode(vm.DirMode)
+	}
+
+	return 0755
+}
+
+// own sets the mode, regardless of the umask, and the owner of a path of
+// the volume
+func (vm *VolumeMount) own(p string, mode os.FileMode) error {
+	if err := os.Chmod(p, mode); err != nil {
+		return err
+	}
+
+	if vm.UID >= 0 || vm.GID >= 0 {
+		return os.Chown(p, vm.UID, vm.GID)
+	}
+
+	return nil
+}
+
+// mkdirAll creates a folder of the volume and its missing parents with the
+// mode and owner of the volume
+func (vm *VolumeMount) mkdirAll(p string) error {
+	if _, err := os.Stat(p); err == nil {
+		return nil
+	}
+
+	if err := vm.mkdirAll(filepath.Dir(p)); err != nil {
+		return err
+	}
+
+	if err := os.Mkdir(p, vm.dirMode()); err != nil {
+		return err
+	}
+
+	return vm.own(p, vm.dirMode())
+}
+
+// isFolderVolume tells if a volume is a folder before asking the store
+func isFolderVolume(vm *VolumeMount) bool {
+	if len(vm.Type) > 0 {
+		return vm.Type == "dir"
+	}
+
+	return strings.HasSuffix(vm.Relative, "/")
+}
+
 // volumeExist
 func (v *ConfigVolume) volumeExist(name string) bool {
 	_, ok := v.volumes[name]
 	return ok
 }
 
+// volume looks up a volume in the registry
+func (v *ConfigVolume) volume(name string) (*VolumeMount, bool) {
+	v.m.Lock()
+	defer v.m.Unlock()
+
+	vm, ok := v.volumes[name]
+	return vm, ok
+}
+
+// syncVolume syncs a volume. Concurrent syncs of the same volume share a
+// single round-trip to the store
+func (v *ConfigVolume) syncVolume(name string, vm *VolumeMount) error {
+	_, err, _ := v.syncs.Do(name, func() (interface{}, error) {
+		vm.syncM.Lock()
+		defer vm.syncM.Unlock()
+
+		return nil, v.syncMountPoint(vm)
+	})
+
+	return err
+}
+
 // Create is called when a volume didn't exist yet
 // In this case a former Get call returned
 func (v *ConfigVolume) Create(r *volume.CreateRequest) error {
 	v.logger.Debugf("Create volume %s", r.Name)
 
-	v.m.Lock()
-	defer v.m.Unlock()
-
 	// already loaded
+	if _, ok := v.volume(r.Name); ok {
+		return nil
+	}
+
+	// set up the stores of the volume without blocking the other volumes
+	vm, err := v.newVolumeMount(r.Name, r.Options)
+	if err != nil {
+		return err
+	}
+
+	v.m.Lock()
 	if v.volumeExist(r.Name) {
+		v.m.Unlock()
 		return nil
 	}
 
-	// create base dir
-	volumePath := filepath.Join(v.mountPoint, r.Name)
+	// a folder volume would replace the volumes inside on every sync
+	if other, ok := v.overlapping(vm.Root); ok {
+		v.m.Unlock()
+		return errors.New("Volume " + r.Name + " overlaps volume " + other)
+	}
+
+	v.volumes[r.Name] = vm
+	v.m.Unlock()
+
+	v.saveState()
+	return nil
+}
+
+// newVolumeMount sets up a volume from its name and options
+func (v *ConfigVolume) newVolumeMount(name string, options map[string]string) (*VolumeMount, error) {
+	// the volume has to stay below the root path
+	volumePath, err := SafeJoin(v.mountPoint, name)
+	if err != nil {
+		return nil, errors.New("Volume name " + name + " leaves the root path")
+	}
+
+	if isReserved(name) {
+		return nil, errors.New("Volume name " + name + " uses the reserved prefix ..")
+	}
 
 	vm := &VolumeMount{
 		Root:             volumePath,
-		Relative:         r.Name,
+		Relative:         name,
 		ReferenceCounter: 0,
+		Options:          options,
+		name:             name,
+		m:                &sync.Mutex{},
+		syncM:            &sync.Mutex{},
+		Strict:           v.configuration.Driver.Strict,
+	}
+
+	// the key lives elsewhere than the name says
+	if key, ok := options["key"]; ok && len(key) > 0 {
+		vm.Relative = key
 	}
 
 	// template mode
-	if v, ok := r.Options["tmpl"]; ok && len(v) > 0 {
+	if v, ok := options["tmpl"]; ok && len(v) > 0 {
 		vm.TemplateGenerator = true
 	}
 
+	// render only the files with this suffix
+	if suffix, ok := options["tmplsuffix"]; ok && len(suffix) > 0 {
+		if strings.Contains(suffix, "/") {
+			return nil, errors.New("Invalid tmplsuffix value " + suffix)
+		}
+
+		vm.TemplateSuffix = suffix
+	}
+
 	// mode bits
-	if v, ok := r.Options["mode"]; ok && len(v) > 0 {
+	if v, ok := options["mode"]; ok && len(v) > 0 {
 		if m, err := strconv.ParseInt(v, 8, 64); err == nil {
 			vm.Mode = int(m)
 		}
 	}
 
-	v.volumes[r.Name] = vm
-	return nil
+	// folder mode bits
+	if v, ok := options["dirmode"]; ok && len(v) > 0 {
+		m, err := strconv.ParseUint(v, 8, 32)
+		if err != nil || m > 0777 {
+			return nil, errors.New("Invalid dirmode value " + v)
+		}
+
+		vm.DirMode = int(m)
+	}
+
+	// owner of the files and folders
+	if vm.UID, err = parseID(options, "uid"); err != nil {
+		return nil, err
+	}
+
+	if vm.GID, err = parseID(options, "gid"); err != nil {
+		return nil, err
+	}
+
+	// fail the mount instead of serving broken content
+	if s, ok := options["strict"]; ok && len(s) > 0 {
+		strict, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, errors.New("Invalid strict value " + s)
+		}
+
+		vm.Strict = strict
+	}
+
+	// StoreGet and StoreList fail on missing keys
+	if s, ok := options["tmplstrict"]; ok && len(s) > 0 {
+		strict, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, errors.New("Invalid tmplstrict value " + s)
+		}
+
+		vm.TemplateStrict = strict
+	}
+
+	// file or folder, regardless of the key
+	if t, ok := options["type"]; ok && len(t) > 0 {
+		if t != "file" && t != "dir" {
+			return nil, errors.New("Unknown type " + t + ", use file or dir")
+		}
+
+		vm.Type = t
+	}
+
+	// select a backend profile
+	s := v.store
+	if backend, ok := options["backend"]; ok && len(backend) > 0 {
+		ns, err := v.namedStore(backend)
+		if err != nil {
+			return nil, err
+		}
+
+		vm.Backend = backend
+		vm.store = ns
+		s = ns
+	}
+
+	// pin the volume to a revision of the backend
+	if rev, ok := options["ref"]; ok && len(rev) > 0 {
+		rs, ok := s.(RevisionStore)
+		if !ok {
+			return nil, errors.New("The backend does not support the ref option")
+		}
+
+		pinned, err := rs.AtRevision(rev)
+		if err != nil {
+			return nil, err
+		}
+
+		vm.Revision = rev
+		vm.store = pinned
+	}
+
+	return vm, nil
+}
+
+// parseID reads an uid or gid option, -1 if it is not set
+func parseID(options map[string]string, opt string) (int, error) {
+	v, ok := options[opt]
+	if !ok || len(v) == 0 {
+		return -1, nil
+	}
+
+	id, err := strconv.Atoi(v)
+	if err != nil || id < 0 {
+		return -1, errors.New("Invalid " + opt + " value " + v)
+	}
+
+	return id, nil
 }
 
 // List returns a list of the available volumes
@@ -177,14 +620,12 @@ func (v *ConfigVolume) List() (*volume.ListResponse, error) {
  */
 func (v *ConfigVolume) Get(r *volume.GetRequest) (*volume.GetResponse, error) {
 	v.logger.Debugf("Get volume %s", r.Name)
-	v.m.Lock()
-	defer v.m.Unlock()
 
-	if v.volumeExist(r.Name) {
+	if vm, ok := v.lookup(r.Name); ok {
 		return &volume.GetResponse{
 			Volume: &volume.Volume{
 				Name:       r.Name,
-				Mountpoint: v.volumes[r.Name].Root,
+				Mountpoint: vm.Root,
 			},
 		}, nil
 	}
@@ -196,27 +637,88 @@ func (v *ConfigVolume) Get(r *volume.GetRequest) (*volume.GetResponse, error) {
 func (v *ConfigVolume) Remove(r *volume.RemoveRequest) error {
 	v.logger.Debugf("Remove volume %s", r.Name)
 	v.m.Lock()
-	defer v.m.Unlock()
-
-	if v.volumeExist(r.Name) {
-		os.RemoveAll(v.volumes[r.Name].Root)
-		delete(v.volumes, r.Name)
+	vm, ok := v.volumes[r.Name]
+	delete(v.volumes, r.Name)
+	v.m.Unlock()
+
+	if ok {
+		vm.syncM.Lock()
+		vm.m.Lock()
+		v.stopWatch(vm)
+		keys := watchKeysOf(vm)
+		vm.m.Unlock()
+		os.RemoveAll(vm.Root)
+		vm.syncM.Unlock()
+
+		v.purgeKeys(vm, keys)
+		v.saveState()
 	}
 
 	return nil
 }
 
+// purgeKeys drops the copies the store of a removed volume keeps of its
+// keys, unless another volume of the same store still reads them
+func (v *ConfigVolume) purgeKeys(removed *VolumeMount, keys []watchKey) {
+	// the store of the volume is unknown
+	if removed.setupErr != nil {
+		return
+	}
+
+	s := v.storeOf(removed)
+	if _, ok := s.(Purger); !ok {
+		return
+	}
+
+	used := []string{}
+	v.m.Lock()
+	for _, vm := range v.volumes {
+		if v.storeOf(vm) != s {
+			continue
+		}
+
+		vm.m.Lock()
+		for _, wk := range watchKeysOf(vm) {
+			used = append(used, wk.key)
+		}
+		vm.m.Unlock()
+	}
+	v.m.Unlock()
+
+	for _, wk := range keys {
+		if keyInUse(wk.key, used) {
+			continue
+		}
+
+		if err := purge(s, wk.key); err != nil {
+			v.logger.Error(err)
+		}
+	}
+}
+
+// keyInUse tells if key is one of used, below one of them or above
+func keyInUse(key string, used []string) bool {
+	for _, u := range used {
+		_, below := relativeKey(u, key)
+		_, above := relativeKey(key, u)
+
+		if below || above || strings.Trim(u, "/") == strings.Trim(key, "/") {
+			return true
+		}
+	}
+
+	return false
+}
+
 // Path
 func (v *ConfigVolume) Path(r *volume.PathRequest) (*volume.PathResponse, error) {
 	v.logger.Debugf("Path %s", r.Name)
-	v.m.Lock()
-	defer v.m.Unlock()
 
 	res := &volume.PathResponse{}
 
-	if v.volumeExist(r.Name) {
+	if vm, ok := v.lookup(r.Name); ok {
 		res = &volume.PathResponse{
-			Mountpoint: v.volumes[r.Name].Root,
+			Mountpoint: vm.Root,
 		}
 	}
 
@@ -225,15 +727,39 @@ func (v *ConfigVolume) Path(r *volume.PathRequest) (*volume.PathResponse, error)
 
 // Mount can be used for ressource allocation
 func (v *ConfigVolume) Mount(r *volume.MountRequest) (*volume.MountResponse, error) {
-	v.logger.Debugf("Remove volume %s", r.Name)
-	v.m.Lock()
-	defer v.m.Unlock()
+	v.logger.Debugf("Mount volume %s", r.Name)
 
 	res := &volume.MountResponse{}
 
-	if vm, ok := v.volumes[r.Name]; ok {
-		v.syncMountPoint(vm)
+	if vm, ok := v.lookup(r.Name); ok {
+		// the volume failed to set up on start
+		if vm.setupErr != nil {
+			var err error
+			if vm, err = v.retrySetup(r.Name, vm); err != nil {
+				return nil, fmt.Errorf("Failed to set up volume %s: %s", r.Name, err)
+			}
+		}
+
+		vm.m.Lock()
 		vm.ReferenceCounter += 1
+		vm.MountID = r.ID
+		vm.m.Unlock()
+
+		// watch before syncing, so no change gets lost
+		v.startWatch(vm)
+
+		if err := v.syncVolume(r.Name, vm); err != nil && vm.Strict {
+			vm.m.Lock()
+			vm.ReferenceCounter -= 1
+			if vm.ReferenceCounter <= 0 {
+				v.stopWatch(vm)
+			}
+			vm.m.Unlock()
+
+			return nil, fmt.Errorf("Failed to sync volume %s: %s", r.Name, err)
+		}
+
+		v.saveState()
 		res = &volume.MountResponse{
 			Mountpoint: vm.Root,
 		}
@@ -245,11 +771,18 @@ func (v *ConfigVolume) Mount(r *volume.MountRequest) (*volume.MountResponse, err
 // Unmount
 func (v *ConfigVolume) Unmount(r *volume.UnmountRequest) error {
 	v.logger.Debugf("Unmounting volume %s", r.Name)
-	v.m.Lock()
-	defer v.m.Unlock()
 
-	if vm, ok := v.volumes[r.Name]; ok {
+	if vm, ok := v.volume(r.Name); ok {
+		vm.m.Lock()
 		vm.ReferenceCounter -= 1
+
+		// nobody uses the volume anymore, stop updating it
+		if vm.ReferenceCounter <= 0 {
+			v.stopWatch(vm)
+		}
+		vm.m.Unlock()
+
+		v.saveState()
 	}
 
 	return nil
@@ -266,11 +799,23 @@ func (v *ConfigVolume) Capabilities() *volume.CapabilitiesResponse {
 
 // NewConfigVolume creates a new ConfigVolume
 func NewConfigVolume(c *Configuration, l *logrus.Logger, s Store) (*ConfigVolume, error) {
-	return &ConfigVolume{
-		logger:     l,
-		volumes:    make(map[string]*VolumeMount),
-		m:          &sync.Mutex{},
-		mountPoint: c.Driver.RootPath,
-		store:      s,
-	}, nil
+	v := &ConfigVolume{
+		logger:        l,
+		volumes:       make(map[string]*VolumeMount),
+		m:             &sync.Mutex{},
+		stateM:        &sync.Mutex{},
+		syncs:         &singleflight.Group{},
+		mountPoint:    c.Driver.RootPath,
+		store:         s,
+		stores:        make(map[string]Store),
+		configuration: c,
+	}
+
+	// volumes created before a restart
+	if err := v.loadState(); err != nil {
+		return nil, err
+	}
+	v.restoreMounts()
+
+	return v, nil
 }
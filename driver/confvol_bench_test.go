@@ -0,0 +1,171 @@
+package driver_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/axelspringer/docker-conf-volume/driver"
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/docker/libkv/store"
+	"github.com/sirupsen/logrus"
+)
+
+// memStore is an in memory store. Every call costs a simulated round trip
+type memStore struct {
+	values  map[string]string
+	latency time.Duration
+	calls   int32
+}
+
+// memTreeStore is a memStore with bulk tree fetch
+type memTreeStore struct {
+	*memStore
+}
+
+func (s *memStore) roundTrip() {
+	atomic.AddInt32(&s.calls, 1)
+	time.Sleep(s.latency)
+}
+
+func (s *memStore) Get(key string) (*StoreKVPair, error) {
+	s.roundTrip()
+
+	if v, ok := s.values[key]; ok {
+		return &StoreKVPair{Key: key, Value: []byte(v)}, nil
+	}
+
+	return nil, store.ErrKeyNotFound
+}
+
+func (s *memStore) List(key string) ([]*StoreKVPair, error) {
+	s.roundTrip()
+
+	prefix := strings.TrimSuffix(key, "/") + "/"
+	children := map[string]*StoreKVPair{}
+
+	for k, v := range s.values {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+
+		rest := k[len(prefix):]
+		if i := strings.Index(rest, "/"); i >= 0 {
+			folder := prefix + rest[:i+1]
+			children[folder] = &StoreKVPair{Key: folder, IsDir: true}
+			continue
+		}
+
+		children[k] = &StoreKVPair{Key: k, Value: []byte(v)}
+	}
+
+	return sortedEntries(children)
+}
+
+func (s *memStore) Watch(key string, stopCh <-chan struct{}) (<-chan *StoreKVPair, error) {
+	return staticWatch(key, stopCh), nil
+}
+
+func (s *memStore) WatchTree(key string, stopCh <-chan struct{}) (<-chan []*StoreKVPair, error) {
+	return staticWatchTree(stopCh), nil
+}
+
+func (s *memTreeStore) ListTree(key string) ([]*StoreKVPair, error) {
+	s.roundTrip()
+
+	prefix := strings.TrimSuffix(key, "/") + "/"
+	entries := map[string]*StoreKVPair{}
+
+	for k, v := range s.values {
+		if strings.HasPrefix(k, prefix) {
+			entries[k] = &StoreKVPair{Key: k, Value: []byte(v)}
+		}
+	}
+
+	return sortedEntries(entries)
+}
+
+// sortedEntries orders entries by key, store.ErrKeyNotFound if there are none
+func sortedEntries(entries map[string]*StoreKVPair) ([]*StoreKVPair, error) {
+	if len(entries) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+
+	keys := []string{}
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	sorted := []*StoreKVPair{}
+	for _, k := range keys {
+		sorted = append(sorted, entries[k])
+	}
+
+	return sorted, nil
+}
+
+// newMemStore fills a store with a tree of 10 folders with 30 files each
+func newMemStore(latency time.Duration) *memStore {
+	s := &memStore{values: map[string]string{}, latency: latency}
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 30; j++ {
+			s.values[fmt.Sprintf("app/conf%d/file%d", i, j)] = strings.Repeat("x", 64)
+		}
+	}
+
+	return s
+}
+
+// benchmarkFolderSync mounts a folder volume again and again and reports
+// the store calls of every sync
+func benchmarkFolderSync(b *testing.B, s Store, m *memStore) {
+	root, err := ioutil.TempDir("", "confvol-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	conf := NewConfiguration()
+	conf.Driver.RootPath = root
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+
+	cv, err := NewConfigVolume(conf, logger, s)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	if err := cv.Create(&volume.CreateRequest{Name: "app/"}); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := cv.Mount(&volume.MountRequest{Name: "app/", ID: "c1"}); err != nil {
+			b.Fatal(err)
+		}
+
+		if err := cv.Unmount(&volume.UnmountRequest{Name: "app/", ID: "c1"}); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportMetric(float64(atomic.LoadInt32(&m.calls))/float64(b.N), "calls/op")
+}
+
+func BenchmarkFolderSyncWalk(b *testing.B) {
+	s := newMemStore(time.Millisecond)
+	benchmarkFolderSync(b, s, s)
+}
+
+func BenchmarkFolderSyncTree(b *testing.B) {
+	s := newMemStore(time.Millisecond)
+	benchmarkFolderSync(b, &memTreeStore{s}, s)
+}
@@ -0,0 +1,1165 @@
+package driver_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	. "github.com/axelspringer/docker-conf-volume/driver"
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/docker/libkv/store"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// slowWatchStore is a StoreMock whose watches deliver the current state
+// only once release is closed
+type slowWatchStore struct {
+	*StoreMock
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *slowWatchStore) Watch(p string, stopCh <-chan struct{}) (<-chan *StoreKVPair, error) {
+	select {
+	case s.started <- struct{}{}:
+	default:
+	}
+
+	ch := make(chan *StoreKVPair, 1)
+	go func() {
+		<-s.release
+		ch <- &StoreKVPair{Key: p}
+		<-stopCh
+		close(ch)
+	}()
+
+	return ch, nil
+}
+
+var _ = Describe("ConfigVolume", func() {
+	var (
+		root string
+		conf *Configuration
+		cv   *ConfigVolume
+	)
+
+	BeforeEach(func() {
+		var err error
+		root, err = ioutil.TempDir("", "confvol-root")
+		Expect(err).To(BeNil())
+
+		conf = NewConfiguration()
+		conf.Driver.RootPath = root
+
+		cv, err = NewConfigVolume(conf, logrus.New(), newDatatreeStore())
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(root)
+	})
+
+	// mount creates and mounts a volume
+	mount := func(name string, opts map[string]string) string {
+		Expect(cv.Create(&volume.CreateRequest{Name: name, Options: opts})).To(Succeed())
+
+		res, err := cv.Mount(&volume.MountRequest{Name: name, ID: "c1"})
+		Expect(err).To(BeNil())
+
+		return res.Mountpoint
+	}
+
+	Context("Mount", func() {
+		It("syncs a single file", func() {
+			data, err := ioutil.ReadFile(mount("dev/auth/mysql/root", nil))
+			Expect(err).To(BeNil())
+			Expect(string(data)).To(Equal("S3cR37"))
+		})
+
+		It("syncs a folder", func() {
+			p := mount("dev/nginx/etc/nginx/", nil)
+
+			data, err := ioutil.ReadFile(filepath.Join(p, "conf.d", "site.conf"))
+			Expect(err).To(BeNil())
+			Expect(string(data)).To(ContainSubstring("listen       8080;"))
+		})
+
+		It("syncs the key option instead of the name", func() {
+			p := mount("mysql-root", map[string]string{"key": "dev/auth/mysql/root"})
+			Expect(p).To(Equal(filepath.Join(root, "mysql-root")))
+			Expect(ioutil.ReadFile(p)).To(Equal([]byte("S3cR37")))
+
+			p = mount("nginx-conf", map[string]string{"key": "dev/nginx/etc/nginx/"})
+			Expect(ioutil.ReadFile(filepath.Join(p, "conf.d", "site.conf"))).To(ContainSubstring("listen       8080;"))
+
+			res, err := cv.List()
+			Expect(err).To(BeNil())
+			Expect(res.Volumes).To(HaveLen(2))
+		})
+	})
+
+	Context("Atomic sync", func() {
+		var (
+			mock  *StoreMock
+			fails bool
+			hook  *test.Hook
+		)
+
+		BeforeEach(func() {
+			var err error
+			fails = false
+			mock = newStoreMock(&map[string]string{"app/a": "1", "app/sub/b": "2"})
+			mock.list = func(key string) ([]*StoreKVPair, error) {
+				if key == "app/sub/" {
+					if fails {
+						return nil, errors.New("connection refused")
+					}
+					return []*StoreKVPair{{Key: "app/sub/b", Value: []byte(mock.kvMap["app/sub/b"])}}, nil
+				}
+				entries := []*StoreKVPair{{Key: "app/sub/", IsDir: true}}
+				if v, ok := mock.kvMap["app/a"]; ok {
+					entries = append(entries, &StoreKVPair{Key: "app/a", Value: []byte(v)})
+				}
+				return entries, nil
+			}
+
+			var logger *logrus.Logger
+			logger, hook = test.NewNullLogger()
+			cv, err = NewConfigVolume(conf, logger, mock)
+			Expect(err).To(BeNil())
+		})
+
+		It("links folder volumes through the current revision", func() {
+			p := mount("app/", nil)
+
+			target, err := os.Readlink(filepath.Join(p, "..data"))
+			Expect(err).To(BeNil())
+			Expect(target).To(HavePrefix("..rev"))
+
+			target, err = os.Readlink(filepath.Join(p, "a"))
+			Expect(err).To(BeNil())
+			Expect(target).To(Equal("..data/a"))
+
+			data, err := ioutil.ReadFile(filepath.Join(p, "sub", "b"))
+			Expect(err).To(BeNil())
+			Expect(string(data)).To(Equal("2"))
+		})
+
+		It("keeps the former revision if a sync fails partway", func() {
+			p := mount("app/", nil)
+
+			mock.kvMap["app/a"] = "changed"
+			fails = true
+			_, err := cv.Mount(&volume.MountRequest{Name: "app/", ID: "c2"})
+			Expect(err).To(BeNil())
+
+			data, err := ioutil.ReadFile(filepath.Join(p, "a"))
+			Expect(err).To(BeNil())
+			Expect(string(data)).To(Equal("1"))
+
+			revisions, err := filepath.Glob(filepath.Join(p, "..rev*"))
+			Expect(err).To(BeNil())
+			Expect(revisions).To(HaveLen(1))
+		})
+
+		It("prunes files whose keys were deleted", func() {
+			p := mount("app/", nil)
+
+			delete(mock.kvMap, "app/a")
+			_, err := cv.Mount(&volume.MountRequest{Name: "app/", ID: "c2"})
+			Expect(err).To(BeNil())
+
+			_, err = os.Lstat(filepath.Join(p, "a"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+
+			messages := []string{}
+			for _, e := range hook.AllEntries() {
+				messages = append(messages, e.Message)
+			}
+			Expect(messages).To(ContainElement("Prune a from volume app/"))
+		})
+
+		It("replaces files without temp files left behind", func() {
+			p := mount("app/a", nil)
+
+			mock.kvMap["app/a"] = "changed"
+			_, err := cv.Mount(&volume.MountRequest{Name: "app/a", ID: "c2"})
+			Expect(err).To(BeNil())
+
+			data, err := ioutil.ReadFile(p)
+			Expect(err).To(BeNil())
+			Expect(string(data)).To(Equal("changed"))
+
+			files, err := ioutil.ReadDir(filepath.Dir(p))
+			Expect(err).To(BeNil())
+			Expect(files).To(HaveLen(1))
+		})
+
+		It("leaves volumes nested in a folder volume alone", func() {
+			nested := mount("app/sub/b", nil)
+
+			// former versions allowed overlapping volumes
+			p := filepath.Join(root, "..state", "volumes.json")
+			data, err := ioutil.ReadFile(p)
+			Expect(err).To(BeNil())
+
+			state := struct {
+				BootID  string                            `json:"bootid"`
+				Volumes map[string]map[string]interface{} `json:"volumes"`
+			}{}
+			Expect(json.Unmarshal(data, &state)).To(Succeed())
+			state.Volumes["app/"] = map[string]interface{}{"root": filepath.Join(root, "app")}
+
+			data, err = json.Marshal(state)
+			Expect(err).To(BeNil())
+			Expect(ioutil.WriteFile(p, data, 0600)).To(Succeed())
+
+			cv, err = NewConfigVolume(conf, logrus.New(), mock)
+			Expect(err).To(BeNil())
+			folder := mount("app/", nil)
+
+			stat, err := os.Lstat(filepath.Join(folder, "sub"))
+			Expect(err).To(BeNil())
+			Expect(stat.IsDir()).To(BeTrue())
+			Expect(ioutil.ReadFile(nested)).To(Equal([]byte("2")))
+			Expect(ioutil.ReadFile(filepath.Join(folder, "a"))).To(Equal([]byte("1")))
+
+			// a sync doesn't replace it either
+			mock.kvMap["app/a"] = "changed"
+			_, err = cv.Mount(&volume.MountRequest{Name: "app/", ID: "c2"})
+			Expect(err).To(BeNil())
+			Expect(ioutil.ReadFile(nested)).To(Equal([]byte("2")))
+		})
+
+		It("updates single files in place for bind mounts", func() {
+			p := mount("app/a", nil)
+
+			// a bind mount holds on to the inode of the file
+			f, err := os.Open(p)
+			Expect(err).To(BeNil())
+			defer f.Close()
+
+			before, err := f.Stat()
+			Expect(err).To(BeNil())
+
+			mock.kvMap["app/a"] = "changed"
+			_, err = cv.Mount(&volume.MountRequest{Name: "app/a", ID: "c2"})
+			Expect(err).To(BeNil())
+
+			data, err := ioutil.ReadAll(f)
+			Expect(err).To(BeNil())
+			Expect(string(data)).To(Equal("changed"))
+
+			after, err := os.Stat(p)
+			Expect(err).To(BeNil())
+			Expect(os.SameFile(before, after)).To(BeTrue())
+
+			// shorter content cuts the former one
+			mock.kvMap["app/a"] = "x"
+			_, err = cv.Mount(&volume.MountRequest{Name: "app/a", ID: "c3"})
+			Expect(err).To(BeNil())
+
+			data, err = ioutil.ReadFile(p)
+			Expect(err).To(BeNil())
+			Expect(string(data)).To(Equal("x"))
+		})
+	})
+
+	Context("Strict mode", func() {
+		var (
+			mock  *StoreMock
+			fails bool
+		)
+
+		strict := map[string]string{"strict": "1"}
+
+		BeforeEach(func() {
+			var err error
+			fails = false
+			mock = newStoreMock(&map[string]string{
+				"app/a":    "1",
+				"app/sub/": "",
+				"app/tmpl": `user={{ StoreGet "app/a" }}`,
+			})
+			mock.get = func(key string) (*StoreKVPair, error) {
+				if v, ok := mock.kvMap[key]; ok {
+					return &StoreKVPair{Key: key, Value: []byte(v)}, nil
+				}
+				return nil, store.ErrKeyNotFound
+			}
+			mock.list = func(key string) ([]*StoreKVPair, error) {
+				if key == "app/sub/" {
+					if fails {
+						return nil, errors.New("connection refused")
+					}
+					return []*StoreKVPair{}, nil
+				}
+				return []*StoreKVPair{{Key: "app/a", Value: []byte(mock.kvMap["app/a"])}, {Key: "app/sub/", IsDir: true}}, nil
+			}
+
+			cv, err = NewConfigVolume(conf, logrus.New(), mock)
+			Expect(err).To(BeNil())
+		})
+
+		remount := func(name string) error {
+			_, err := cv.Mount(&volume.MountRequest{Name: name, ID: "c2"})
+			return err
+		}
+
+		It("fails on missing keys", func() {
+			Expect(cv.Create(&volume.CreateRequest{Name: "app/missing", Options: strict})).To(Succeed())
+
+			_, err := cv.Mount(&volume.MountRequest{Name: "app/missing", ID: "c1"})
+			Expect(err).To(MatchError("Failed to sync volume app/missing: Key not found in store"))
+		})
+
+		It("keeps the former file if the key is gone", func() {
+			p := mount("app/a", strict)
+
+			delete(mock.kvMap, "app/a")
+			Expect(remount("app/a")).NotTo(Succeed())
+			Expect(ioutil.ReadFile(p)).To(Equal([]byte("1")))
+		})
+
+		It("keeps the former output on template errors", func() {
+			p := mount("app/tmpl", map[string]string{"strict": "1", "tmpl": "1"})
+			Expect(ioutil.ReadFile(p)).To(Equal([]byte("user=1")))
+
+			mock.kvMap["app/tmpl"] = `user={{ StoreGet "app/a" }`
+			Expect(remount("app/tmpl")).NotTo(Succeed())
+			Expect(ioutil.ReadFile(p)).To(Equal([]byte("user=1")))
+		})
+
+		It("keeps the former folder revision if a sync fails partway", func() {
+			p := mount("app/", strict)
+
+			mock.kvMap["app/a"] = "2"
+			fails = true
+			Expect(remount("app/")).NotTo(Succeed())
+			Expect(ioutil.ReadFile(filepath.Join(p, "a"))).To(Equal([]byte("1")))
+		})
+
+		It("is enabled for all volumes by the driver settings", func() {
+			conf.Driver.Strict = true
+			Expect(cv.Create(&volume.CreateRequest{Name: "app/missing"})).To(Succeed())
+
+			_, err := cv.Mount(&volume.MountRequest{Name: "app/missing", ID: "c1"})
+			Expect(err).NotTo(BeNil())
+		})
+
+		It("can be disabled per volume", func() {
+			conf.Driver.Strict = true
+			Expect(cv.Create(&volume.CreateRequest{Name: "app/missing", Options: map[string]string{"strict": "0"}})).To(Succeed())
+
+			_, err := cv.Mount(&volume.MountRequest{Name: "app/missing", ID: "c1"})
+			Expect(err).To(BeNil())
+		})
+
+		It("rejects invalid values", func() {
+			err := cv.Create(&volume.CreateRequest{Name: "app/a", Options: map[string]string{"strict": "maybe"}})
+			Expect(err).To(MatchError("Invalid strict value maybe"))
+		})
+	})
+
+	Context("Concurrency", func() {
+		var (
+			mock    *StoreMock
+			release chan struct{}
+			gets    int32
+		)
+
+		BeforeEach(func() {
+			var err error
+			release = make(chan struct{})
+			gets = 0
+
+			kv := map[string]string{}
+			for i := 0; i < 20; i++ {
+				kv[fmt.Sprintf("app/%d", i)] = strconv.Itoa(i)
+			}
+			mock = newStoreMock(&kv)
+			mock.get = func(key string) (*StoreKVPair, error) {
+				atomic.AddInt32(&gets, 1)
+				<-release
+				return &StoreKVPair{Key: key, Value: []byte(mock.kvMap[key])}, nil
+			}
+
+			cv, err = NewConfigVolume(conf, logrus.New(), mock)
+			Expect(err).To(BeNil())
+		})
+
+		It("mounts many volumes in parallel", func() {
+			close(release)
+
+			wg := sync.WaitGroup{}
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer GinkgoRecover()
+					defer wg.Done()
+
+					name := fmt.Sprintf("app/%d", i)
+					Expect(cv.Create(&volume.CreateRequest{Name: name})).To(Succeed())
+
+					res, err := cv.Mount(&volume.MountRequest{Name: name, ID: "c1"})
+					Expect(err).To(BeNil())
+					Expect(ioutil.ReadFile(res.Mountpoint)).To(Equal([]byte(strconv.Itoa(i))))
+
+					_, err = cv.List()
+					Expect(err).To(BeNil())
+					Expect(cv.Unmount(&volume.UnmountRequest{Name: name, ID: "c1"})).To(Succeed())
+				}(i)
+			}
+			wg.Wait()
+
+			res, err := cv.List()
+			Expect(err).To(BeNil())
+			Expect(res.Volumes).To(HaveLen(20))
+		})
+
+		It("serves other calls while a store is slow", func() {
+			Expect(cv.Create(&volume.CreateRequest{Name: "app/0"})).To(Succeed())
+			Expect(cv.Create(&volume.CreateRequest{Name: "app/1"})).To(Succeed())
+
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				defer close(done)
+				_, err := cv.Mount(&volume.MountRequest{Name: "app/0", ID: "c1"})
+				Expect(err).To(BeNil())
+			}()
+
+			Eventually(func() int32 { return atomic.LoadInt32(&gets) }).Should(Equal(int32(1)))
+
+			_, err := cv.Get(&volume.GetRequest{Name: "app/1"})
+			Expect(err).To(BeNil())
+			_, err = cv.Path(&volume.PathRequest{Name: "app/1"})
+			Expect(err).To(BeNil())
+
+			close(release)
+			Eventually(done).Should(BeClosed())
+		})
+
+		It("doesn't lock a volume while its watch is set up", func() {
+			close(release)
+
+			slow := &slowWatchStore{StoreMock: mock, started: make(chan struct{}, 1), release: make(chan struct{})}
+			cv, err := NewConfigVolume(conf, logrus.New(), slow)
+			Expect(err).To(BeNil())
+			Expect(cv.Create(&volume.CreateRequest{Name: "app/0"})).To(Succeed())
+
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				defer close(done)
+				_, err := cv.Mount(&volume.MountRequest{Name: "app/0", ID: "c1"})
+				Expect(err).To(BeNil())
+			}()
+
+			Eventually(slow.started).Should(Receive())
+
+			unmounted := make(chan error)
+			go func() {
+				unmounted <- cv.Unmount(&volume.UnmountRequest{Name: "app/0", ID: "c1"})
+			}()
+			Eventually(unmounted).Should(Receive(BeNil()))
+
+			close(slow.release)
+			Eventually(done).Should(BeClosed())
+		})
+
+		It("syncs once for concurrent mounts of a volume", func() {
+			Expect(cv.Create(&volume.CreateRequest{Name: "app/0"})).To(Succeed())
+
+			wg := sync.WaitGroup{}
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					_, err := cv.Mount(&volume.MountRequest{Name: "app/0", ID: fmt.Sprintf("c%d", i)})
+					Expect(err).To(BeNil())
+				}(i)
+			}
+
+			// let every mount join the pending sync
+			Eventually(func() int32 { return atomic.LoadInt32(&gets) }).Should(Equal(int32(1)))
+			time.Sleep(100 * time.Millisecond)
+			close(release)
+			wg.Wait()
+
+			Expect(atomic.LoadInt32(&gets)).To(Equal(int32(1)))
+		})
+	})
+
+	Context("Bulk fetch", func() {
+		It("syncs a folder tree with a single call", func() {
+			s := &memTreeStore{newMemStore(0)}
+
+			var err error
+			cv, err = NewConfigVolume(conf, logrus.New(), s)
+			Expect(err).To(BeNil())
+
+			mountPoint := mount("app/", nil)
+			Expect(atomic.LoadInt32(&s.calls)).To(Equal(int32(1)))
+			Expect(ioutil.ReadFile(filepath.Join(mountPoint, "conf9/file29"))).To(HaveLen(64))
+		})
+
+		It("walks stores without bulk fetch", func() {
+			s := newMemStore(0)
+
+			var err error
+			cv, err = NewConfigVolume(conf, logrus.New(), s)
+			Expect(err).To(BeNil())
+
+			mountPoint := mount("app/", nil)
+			Expect(atomic.LoadInt32(&s.calls)).To(Equal(int32(11)))
+			Expect(ioutil.ReadFile(filepath.Join(mountPoint, "conf9/file29"))).To(HaveLen(64))
+		})
+	})
+
+	Context("Folder templates", func() {
+		var s *memTreeStore
+
+		BeforeEach(func() {
+			s = &memTreeStore{&memStore{values: map[string]string{
+				"env/host":                  "example.org",
+				"app/conf.d/site.conf.tmpl": `server_name {{ StoreGet "env/host" }};`,
+				"app/conf.d/raw.conf":       `{{ "raw" }}`,
+			}}}
+
+			var err error
+			cv, err = NewConfigVolume(conf, logrus.New(), s)
+			Expect(err).To(BeNil())
+		})
+
+		It("renders every file", func() {
+			p := mount("app/", map[string]string{"tmpl": "1"})
+
+			Expect(ioutil.ReadFile(filepath.Join(p, "conf.d", "site.conf.tmpl"))).To(Equal([]byte("server_name example.org;")))
+			Expect(ioutil.ReadFile(filepath.Join(p, "conf.d", "raw.conf"))).To(Equal([]byte("raw")))
+		})
+
+		It("renders files with the template suffix only", func() {
+			p := mount("app/", map[string]string{"tmplsuffix": ".tmpl"})
+
+			Expect(ioutil.ReadFile(filepath.Join(p, "conf.d", "site.conf"))).To(Equal([]byte("server_name example.org;")))
+			Expect(ioutil.ReadFile(filepath.Join(p, "conf.d", "raw.conf"))).To(Equal([]byte(`{{ "raw" }}`)))
+
+			_, err := os.Stat(filepath.Join(p, "conf.d", "site.conf.tmpl"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("reports failing templates by key", func() {
+			s.values["app/conf.d/broken.conf.tmpl"] = `{{ StoreGet }`
+			Expect(cv.Create(&volume.CreateRequest{Name: "app/", Options: map[string]string{"tmplsuffix": ".tmpl", "strict": "1"}})).To(Succeed())
+
+			_, err := cv.Mount(&volume.MountRequest{Name: "app/", ID: "c1"})
+			Expect(err).NotTo(BeNil())
+			Expect(err.Error()).To(HavePrefix("Failed to sync volume app/: Failed to render app/conf.d/broken.conf.tmpl: "))
+		})
+
+		It("renders with the context of the volume", func() {
+			hostname, err := os.Hostname()
+			Expect(err).To(BeNil())
+
+			os.Setenv("CONFVOL_TEST_STAGE", "live")
+			defer os.Unsetenv("CONFVOL_TEST_STAGE")
+			conf.Generator.Env = []string{"CONFVOL_TEST_STAGE", "CONFVOL_TEST_UNSET"}
+
+			s.values["app/ctx"] = `{{ .Volume }} {{ .Key }} {{ .Template }} {{ index .Options "stage" }} {{ .Hostname }} {{ .Env }} {{ .MountID }}`
+			p := mount("ctx", map[string]string{"key": "app/ctx", "tmpl": "1", "stage": "prod"})
+			Expect(ioutil.ReadFile(p)).To(Equal([]byte("ctx app/ctx app/ctx prod " + hostname + " map[CONFVOL_TEST_STAGE:live] c1")))
+
+			s.values["app/conf.d/site.conf.tmpl"] = `{{ .Volume }} {{ .Key }} {{ .Template }}`
+			p = mount("app/", map[string]string{"tmplsuffix": ".tmpl"})
+			Expect(ioutil.ReadFile(filepath.Join(p, "conf.d", "site.conf"))).To(Equal([]byte("app/ app/ app/conf.d/site.conf.tmpl")))
+		})
+
+		It("fails on missing keys with strict helpers", func() {
+			s.values["app/htpasswd"] = `admin:{{ StoreGet "app/pasword" }}`
+			opts := map[string]string{"tmpl": "1", "tmplstrict": "1", "strict": "1"}
+			Expect(cv.Create(&volume.CreateRequest{Name: "app/htpasswd", Options: opts})).To(Succeed())
+
+			_, err := cv.Mount(&volume.MountRequest{Name: "app/htpasswd", ID: "c1"})
+			Expect(err).To(MatchError(ContainSubstring("Failed to get app/pasword: Key not found in store")))
+
+			err = cv.Create(&volume.CreateRequest{Name: "app/a", Options: map[string]string{"tmplstrict": "maybe"}})
+			Expect(err).To(MatchError("Invalid tmplstrict value maybe"))
+		})
+
+		It("rejects suffixes with a slash", func() {
+			err := cv.Create(&volume.CreateRequest{Name: "app/", Options: map[string]string{"tmplsuffix": "/x"}})
+			Expect(err).To(MatchError("Invalid tmplsuffix value /x"))
+		})
+	})
+
+	Context("Ownership", func() {
+		// owner returns the uid and gid of a path
+		owner := func(p string) (uint32, uint32) {
+			info, err := os.Stat(p)
+			Expect(err).To(BeNil())
+
+			stat := info.Sys().(*syscall.Stat_t)
+			return stat.Uid, stat.Gid
+		}
+
+		It("applies the modes to every file and folder", func() {
+			p := mount("dev/nginx/etc/", map[string]string{"mode": "0600", "dirmode": "0750"})
+
+			for _, dir := range []string{p, filepath.Join(p, "nginx"), filepath.Join(p, "nginx", "conf.d")} {
+				info, err := os.Stat(dir)
+				Expect(err).To(BeNil())
+				Expect(info.Mode().Perm()).To(Equal(os.FileMode(0750)))
+			}
+
+			info, err := os.Stat(filepath.Join(p, "nginx", "conf.d", "site.conf"))
+			Expect(err).To(BeNil())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+		})
+
+		It("applies the folder mode to the parents of single files", func() {
+			mount("dev/auth/mysql/root", map[string]string{"dirmode": "0750"})
+
+			for _, dir := range []string{"dev", "dev/auth", "dev/auth/mysql"} {
+				info, err := os.Stat(filepath.Join(root, dir))
+				Expect(err).To(BeNil())
+				Expect(info.Mode().Perm()).To(Equal(os.FileMode(0750)))
+			}
+		})
+
+		It("fails if the parents of a single file can't be created", func() {
+			Expect(ioutil.WriteFile(filepath.Join(root, "dev"), []byte("in the way"), 0644)).To(Succeed())
+			Expect(cv.Create(&volume.CreateRequest{Name: "dev/auth/mysql/root", Options: map[string]string{"strict": "1"}})).To(Succeed())
+
+			_, err := cv.Mount(&volume.MountRequest{Name: "dev/auth/mysql/root", ID: "c1"})
+			Expect(err).To(MatchError(ContainSubstring("not a directory")))
+		})
+
+		It("applies the owner to every file and folder", func() {
+			if os.Getuid() != 0 {
+				Skip("changing the owner needs root")
+			}
+
+			p := mount("dev/nginx/etc/", map[string]string{"uid": "1234", "gid": "4321"})
+			for _, f := range []string{p, filepath.Join(p, "nginx", "conf.d"), filepath.Join(p, "nginx", "conf.d", "site.conf")} {
+				uid, gid := owner(f)
+				Expect(uid).To(Equal(uint32(1234)))
+				Expect(gid).To(Equal(uint32(4321)))
+			}
+
+			p = mount("dev/auth/mysql/root", map[string]string{"uid": "1234", "mode": "0400"})
+			for _, f := range []string{p, filepath.Join(root, "dev/auth/mysql")} {
+				uid, gid := owner(f)
+				Expect(uid).To(Equal(uint32(1234)))
+				Expect(gid).To(Equal(uint32(os.Getgid())))
+			}
+		})
+
+		It("rejects invalid values", func() {
+			err := cv.Create(&volume.CreateRequest{Name: "app/a", Options: map[string]string{"uid": "nobody"}})
+			Expect(err).To(MatchError("Invalid uid value nobody"))
+
+			err = cv.Create(&volume.CreateRequest{Name: "app/a", Options: map[string]string{"gid": "-1"}})
+			Expect(err).To(MatchError("Invalid gid value -1"))
+
+			err = cv.Create(&volume.CreateRequest{Name: "app/a", Options: map[string]string{"dirmode": "0999"}})
+			Expect(err).To(MatchError("Invalid dirmode value 0999"))
+		})
+	})
+
+	Context("Path traversal", func() {
+		It("rejects volume names leaving the root path", func() {
+			for _, name := range []string{"../etc", "dev/../../etc/", "/", "."} {
+				err := cv.Create(&volume.CreateRequest{Name: name})
+				Expect(err).To(MatchError("Volume name " + name + " leaves the root path"))
+			}
+
+			err := cv.Create(&volume.CreateRequest{Name: "app/..data"})
+			Expect(err).To(MatchError("Volume name app/..data uses the reserved prefix .."))
+		})
+
+		It("rejects keys leaving the volume", func() {
+			s := &memTreeStore{&memStore{values: map[string]string{"app/a": "1", "app/../../escaped": "x"}}}
+
+			var err error
+			cv, err = NewConfigVolume(conf, logrus.New(), s)
+			Expect(err).To(BeNil())
+			Expect(cv.Create(&volume.CreateRequest{Name: "app/", Options: map[string]string{"strict": "1"}})).To(Succeed())
+
+			_, err = cv.Mount(&volume.MountRequest{Name: "app/", ID: "c1"})
+			Expect(err).To(MatchError("Failed to sync volume app/: Key app/../../escaped leaves the volume app/"))
+
+			_, err = os.Stat(filepath.Join(filepath.Dir(root), "escaped"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("rejects keys replacing the links of the volume", func() {
+			s := &memTreeStore{&memStore{values: map[string]string{"app/..data": "x"}}}
+
+			var err error
+			cv, err = NewConfigVolume(conf, logrus.New(), s)
+			Expect(err).To(BeNil())
+			Expect(cv.Create(&volume.CreateRequest{Name: "app/", Options: map[string]string{"strict": "1"}})).To(Succeed())
+
+			_, err = cv.Mount(&volume.MountRequest{Name: "app/", ID: "c1"})
+			Expect(err).To(MatchError("Failed to sync volume app/: Key app/..data uses the reserved prefix .."))
+		})
+	})
+
+	Context("Files and folders", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "confvol-types")
+			Expect(err).To(BeNil())
+
+			Expect(os.MkdirAll(filepath.Join(dir, "dev/auth/nginx"), os.ModePerm)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "dev/auth/nginx/admin"), []byte{}, 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "dev/auth/nginx/user"), []byte("bob"), 0644)).To(Succeed())
+
+			conf.Backend.Type = "file"
+			conf.Backend.Path = dir
+
+			s, err := NewStore(conf, logrus.New())
+			Expect(err).To(BeNil())
+
+			cv, err = NewConfigVolume(conf, logrus.New(), s)
+			Expect(err).To(BeNil())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("syncs empty values as files", func() {
+			p := mount("dev/auth/", nil)
+
+			stat, err := os.Stat(filepath.Join(p, "nginx", "admin"))
+			Expect(err).To(BeNil())
+			Expect(stat.IsDir()).To(BeFalse())
+			Expect(stat.Size()).To(BeZero())
+		})
+
+		It("detects folders without a trailing slash", func() {
+			p := mount("dev/auth/nginx", nil)
+
+			data, err := ioutil.ReadFile(filepath.Join(p, "user"))
+			Expect(err).To(BeNil())
+			Expect(string(data)).To(Equal("bob"))
+		})
+
+		It("follows the type option", func() {
+			p := mount("dev/auth/nginx", map[string]string{"type": "file"})
+
+			stat, err := os.Stat(p)
+			Expect(err).To(BeNil())
+			Expect(stat.IsDir()).To(BeFalse())
+		})
+
+		It("rejects volumes overlapping others", func() {
+			Expect(cv.Create(&volume.CreateRequest{Name: "dev/nginx/"})).To(Succeed())
+
+			err := cv.Create(&volume.CreateRequest{Name: "dev/nginx/etc/nginx.conf"})
+			Expect(err).To(MatchError("Volume dev/nginx/etc/nginx.conf overlaps volume dev/nginx/"))
+
+			err = cv.Create(&volume.CreateRequest{Name: "dev/"})
+			Expect(err).To(MatchError("Volume dev/ overlaps volume dev/nginx/"))
+
+			Expect(cv.Create(&volume.CreateRequest{Name: "dev/auth/"})).To(Succeed())
+		})
+
+		It("rejects unknown types", func() {
+			err := cv.Create(&volume.CreateRequest{Name: "dev/auth/nginx", Options: map[string]string{"type": "link"}})
+			Expect(err).To(MatchError("Unknown type link, use file or dir"))
+		})
+	})
+
+	Context("Restart", func() {
+		It("restores created volumes", func() {
+			Expect(cv.Create(&volume.CreateRequest{Name: "dev/auth/mysql/root", Options: map[string]string{"mode": "0600"}})).To(Succeed())
+
+			restarted, err := NewConfigVolume(conf, logrus.New(), newDatatreeStore())
+			Expect(err).To(BeNil())
+
+			res, err := restarted.Get(&volume.GetRequest{Name: "dev/auth/mysql/root"})
+			Expect(err).To(BeNil())
+			Expect(res.Volume.Mountpoint).To(Equal(filepath.Join(root, "dev/auth/mysql/root")))
+		})
+
+		It("syncs mounted volumes again", func() {
+			p := mount("dev/auth/mysql/root", nil)
+			Expect(os.Remove(p)).To(Succeed())
+
+			restarted, err := NewConfigVolume(conf, logrus.New(), newDatatreeStore())
+			Expect(err).To(BeNil())
+			defer restarted.Unmount(&volume.UnmountRequest{Name: "dev/auth/mysql/root", ID: "c1"})
+
+			Eventually(func() ([]byte, error) { return ioutil.ReadFile(p) }).Should(Equal([]byte("S3cR37")))
+		})
+
+		It("doesn't wait for the sync of mounted volumes", func() {
+			mount("dev/auth/mysql/root", nil)
+
+			release := make(chan struct{})
+			defer close(release)
+
+			slow := newStoreMock(&map[string]string{"dev/auth/mysql/root": "S3cR37"})
+			slow.get = func(key string) (*StoreKVPair, error) {
+				<-release
+				return &StoreKVPair{Key: key, Value: []byte("S3cR37")}, nil
+			}
+
+			done := make(chan error)
+			go func() {
+				_, err := NewConfigVolume(conf, logrus.New(), slow)
+				done <- err
+			}()
+
+			Eventually(done).Should(Receive(BeNil()))
+		})
+
+		It("keeps the registry apart from the volumes", func() {
+			Expect(cv.Create(&volume.CreateRequest{Name: ".confvol-state.json"})).To(Succeed())
+			Expect(cv.Create(&volume.CreateRequest{Name: "dev/auth/mysql/root"})).To(Succeed())
+
+			_, err := os.Stat(filepath.Join(root, "..state", "volumes.json"))
+			Expect(err).To(BeNil())
+
+			err = cv.Create(&volume.CreateRequest{Name: "..state"})
+			Expect(err).To(MatchError("Volume name ..state uses the reserved prefix .."))
+
+			restarted, err := NewConfigVolume(conf, logrus.New(), newDatatreeStore())
+			Expect(err).To(BeNil())
+
+			res, err := restarted.List()
+			Expect(err).To(BeNil())
+			Expect(res.Volumes).To(HaveLen(2))
+		})
+
+		It("moves the registry of former versions", func() {
+			// a former version left no registry in ..state
+			Expect(os.RemoveAll(filepath.Join(root, "..state"))).To(Succeed())
+
+			legacy := `{"dev/auth/mysql/root": {"options": {"mode": "0600"}}}`
+			Expect(ioutil.WriteFile(filepath.Join(root, ".confvol-state.json"), []byte(legacy), 0600)).To(Succeed())
+
+			restarted, err := NewConfigVolume(conf, logrus.New(), newDatatreeStore())
+			Expect(err).To(BeNil())
+
+			_, err = restarted.Get(&volume.GetRequest{Name: "dev/auth/mysql/root"})
+			Expect(err).To(BeNil())
+
+			_, err = os.Stat(filepath.Join(root, ".confvol-state.json"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+			_, err = os.Stat(filepath.Join(root, "..state", "volumes.json"))
+			Expect(err).To(BeNil())
+		})
+
+		It("adopts volumes found on disk and removes leftovers", func() {
+			Expect(os.MkdirAll(filepath.Join(root, "dev/auth/mysql"), os.ModePerm)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(root, "dev/auth/mysql/root"), []byte("old"), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(root, "dev/auth/mysql/.root.tmp123"), []byte("half"), 0644)).To(Succeed())
+
+			Expect(os.MkdirAll(filepath.Join(root, "app/..rev1"), os.ModePerm)).To(Succeed())
+			Expect(os.Symlink("..rev1", filepath.Join(root, "app/..data"))).To(Succeed())
+
+			Expect(os.MkdirAll(filepath.Join(root, "empty/sub"), os.ModePerm)).To(Succeed())
+
+			restarted, err := NewConfigVolume(conf, logrus.New(), newDatatreeStore())
+			Expect(err).To(BeNil())
+
+			_, err = restarted.Get(&volume.GetRequest{Name: "dev/auth/mysql/root"})
+			Expect(err).To(BeNil())
+			_, err = restarted.Get(&volume.GetRequest{Name: "app/"})
+			Expect(err).To(BeNil())
+
+			_, err = os.Stat(filepath.Join(root, "dev/auth/mysql/.root.tmp123"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+			_, err = os.Stat(filepath.Join(root, "empty"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("removes only the temp files of interrupted writes", func() {
+			dir := filepath.Join(root, "dev/auth/mysql")
+			Expect(os.MkdirAll(dir, os.ModePerm)).To(Succeed())
+			for _, name := range []string{".root.tmp123", "..tmpdata", ".env.tmpl", ".foo.tmp.conf", ".tmp42"} {
+				Expect(ioutil.WriteFile(filepath.Join(dir, name), []byte("x"), 0644)).To(Succeed())
+			}
+
+			_, err := NewConfigVolume(conf, logrus.New(), newDatatreeStore())
+			Expect(err).To(BeNil())
+
+			files, err := ioutil.ReadDir(dir)
+			Expect(err).To(BeNil())
+
+			names := []string{}
+			for _, f := range files {
+				names = append(names, f.Name())
+			}
+			Expect(names).To(ConsistOf(".env.tmpl", ".foo.tmp.conf", ".tmp42"))
+		})
+
+		It("keeps registered folder volumes of former versions as a whole", func() {
+			Expect(cv.Create(&volume.CreateRequest{Name: "legacy/"})).To(Succeed())
+			Expect(cv.Create(&volume.CreateRequest{Name: "conf", Options: map[string]string{"type": "dir"}})).To(Succeed())
+
+			// former versions wrote plain files, without a ..data link
+			for _, dir := range []string{"legacy", "conf"} {
+				Expect(os.MkdirAll(filepath.Join(root, dir, "sub"), os.ModePerm)).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(root, dir, "a"), []byte("1"), 0644)).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(root, dir, "sub", "b"), []byte("2"), 0644)).To(Succeed())
+			}
+
+			restarted, err := NewConfigVolume(conf, logrus.New(), newDatatreeStore())
+			Expect(err).To(BeNil())
+
+			res, err := restarted.List()
+			Expect(err).To(BeNil())
+
+			names := []string{}
+			for _, vol := range res.Volumes {
+				names = append(names, vol.Name)
+			}
+			Expect(names).To(ConsistOf("legacy/", "conf"))
+			Expect(ioutil.ReadFile(filepath.Join(root, "conf", "sub", "b"))).To(Equal([]byte("2")))
+		})
+
+		It("adopts unregistered volumes by the name docker asks for", func() {
+			Expect(os.MkdirAll(filepath.Join(root, "legacy/sub"), os.ModePerm)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(root, "legacy/a"), []byte("1"), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(root, "legacy/sub/b"), []byte("2"), 0644)).To(Succeed())
+
+			restarted, err := NewConfigVolume(conf, logrus.New(), newDatatreeStore())
+			Expect(err).To(BeNil())
+
+			// the files of a plain folder aren't taken as volumes of their own
+			res, err := restarted.List()
+			Expect(err).To(BeNil())
+			Expect(res.Volumes).To(BeEmpty())
+
+			got, err := restarted.Get(&volume.GetRequest{Name: "legacy/"})
+			Expect(err).To(BeNil())
+			Expect(got.Volume.Mountpoint).To(Equal(filepath.Join(root, "legacy")))
+
+			// nothing inside an adopted volume is adopted again
+			_, err = restarted.Get(&volume.GetRequest{Name: "legacy/a"})
+			Expect(err).To(MatchError("Element not found"))
+			_, err = restarted.Get(&volume.GetRequest{Name: "do/not/exist"})
+			Expect(err).To(MatchError("Element not found"))
+
+			res, err = restarted.List()
+			Expect(err).To(BeNil())
+			Expect(res.Volumes).To(HaveLen(1))
+		})
+
+		It("resets the mount counts after a reboot of the host", func() {
+			if _, err := os.Stat("/proc/sys/kernel/random/boot_id"); err != nil {
+				Skip("the boot id of the host is unknown")
+			}
+
+			mount("dev/auth/mysql/root", nil)
+
+			p := filepath.Join(root, "..state", "volumes.json")
+			data, err := ioutil.ReadFile(p)
+			Expect(err).To(BeNil())
+
+			state := map[string]interface{}{}
+			Expect(json.Unmarshal(data, &state)).To(Succeed())
+			Expect(state["bootid"]).NotTo(BeEmpty())
+			state["bootid"] = "former-boot"
+
+			data, err = json.Marshal(state)
+			Expect(err).To(BeNil())
+			Expect(ioutil.WriteFile(p, data, 0600)).To(Succeed())
+
+			_, err = NewConfigVolume(conf, logrus.New(), newDatatreeStore())
+			Expect(err).To(BeNil())
+
+			data, err = ioutil.ReadFile(p)
+			Expect(err).To(BeNil())
+			Expect(string(data)).To(ContainSubstring(`"refcount": 0`))
+			Expect(string(data)).NotTo(ContainSubstring("former-boot"))
+		})
+
+		It("forgets removed volumes", func() {
+			mount("dev/auth/mysql/root", nil)
+			Expect(cv.Remove(&volume.RemoveRequest{Name: "dev/auth/mysql/root"})).To(Succeed())
+
+			restarted, err := NewConfigVolume(conf, logrus.New(), newDatatreeStore())
+			Expect(err).To(BeNil())
+
+			_, err = restarted.Get(&volume.GetRequest{Name: "dev/auth/mysql/root"})
+			Expect(err).To(MatchError("Element not found"))
+		})
+
+		It("keeps volumes whose backend is gone until it is back", func() {
+			local := map[string]BackendSettings{
+				"local": {Type: "file", Path: "../examples/etcd_datatree"},
+			}
+			conf.Backends = local
+			p := mount("dev/auth/mysql/root", map[string]string{"backend": "local"})
+			Expect(cv.Unmount(&volume.UnmountRequest{Name: "dev/auth/mysql/root", ID: "c1"})).To(Succeed())
+
+			conf.Backends = nil
+			restarted, err := NewConfigVolume(conf, logrus.New(), newDatatreeStore())
+			Expect(err).To(BeNil())
+
+			_, err = restarted.Get(&volume.GetRequest{Name: "dev/auth/mysql/root"})
+			Expect(err).To(BeNil())
+			Expect(ioutil.ReadFile(p)).To(Equal([]byte("S3cR37")))
+
+			_, err = restarted.Mount(&volume.MountRequest{Name: "dev/auth/mysql/root", ID: "c2"})
+			Expect(err).To(MatchError("Failed to set up volume dev/auth/mysql/root: Unknown backend local"))
+
+			// the options survive another restart
+			restarted, err = NewConfigVolume(conf, logrus.New(), newDatatreeStore())
+			Expect(err).To(BeNil())
+
+			conf.Backends = local
+			res, err := restarted.Mount(&volume.MountRequest{Name: "dev/auth/mysql/root", ID: "c3"})
+			Expect(err).To(BeNil())
+			Expect(res.Mountpoint).To(Equal(p))
+			Expect(restarted.Unmount(&volume.UnmountRequest{Name: "dev/auth/mysql/root", ID: "c3"})).To(Succeed())
+		})
+	})
+
+	Context("Backend profiles", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "confvol-profile")
+			Expect(err).To(BeNil())
+
+			Expect(os.MkdirAll(filepath.Join(dir, "dev/auth/mysql"), os.ModePerm)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "dev/auth/mysql/root"), []byte("local"), 0644)).To(Succeed())
+
+			conf.Backends = map[string]BackendSettings{
+				"local": {Type: "file", Path: dir},
+			}
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("mounts from the selected backend", func() {
+			data, err := ioutil.ReadFile(mount("dev/auth/mysql/root", map[string]string{"backend": "local"}))
+			Expect(err).To(BeNil())
+			Expect(string(data)).To(Equal("local"))
+		})
+
+		It("rejects unknown backends", func() {
+			err := cv.Create(&volume.CreateRequest{Name: "dev/auth/mysql/root", Options: map[string]string{"backend": "prod"}})
+			Expect(err).To(MatchError("Unknown backend prod"))
+		})
+
+		It("rejects ref on backends without revisions", func() {
+			err := cv.Create(&volume.CreateRequest{Name: "dev/auth/mysql/root", Options: map[string]string{"backend": "local", "ref": "v1"}})
+			Expect(err).To(MatchError("The backend does not support the ref option"))
+		})
+	})
+})
+
+var _ = Describe("ConfigVolume updates", func() {
+	var (
+		root string
+		dir  string
+		cv   *ConfigVolume
+	)
+
+	BeforeEach(func() {
+		var err error
+		root, err = ioutil.TempDir("", "confvol-root")
+		Expect(err).To(BeNil())
+
+		dir, err = ioutil.TempDir("", "confvol-watch")
+		Expect(err).To(BeNil())
+
+		Expect(os.MkdirAll(filepath.Join(dir, "dev/nginx/htdocs"), os.ModePerm)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "dev/nginx/site.conf"), []byte("v1"), 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "dev/nginx/htdocs/index.html"), []byte("v1"), 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "dev/nginx/user"), []byte("admin"), 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "dev/nginx/auth.tmpl"), []byte(`user={{ StoreGet "dev/nginx/user" }}`), 0644)).To(Succeed())
+
+		conf := NewConfiguration()
+		conf.Driver.RootPath = root
+		conf.Backend.Type = "file"
+		conf.Backend.Path = dir
+		conf.Backend.PollInterval = 1
+
+		s, err := NewStore(conf, logrus.New())
+		Expect(err).To(BeNil())
+
+		cv, err = NewConfigVolume(conf, logrus.New(), s)
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(root)
+		os.RemoveAll(dir)
+	})
+
+	// mount creates and mounts a volume
+	mount := func(name string, opts map[string]string) string {
+		Expect(cv.Create(&volume.CreateRequest{Name: name, Options: opts})).To(Succeed())
+
+		res, err := cv.Mount(&volume.MountRequest{Name: name, ID: "c1"})
+		Expect(err).To(BeNil())
+
+		return res.Mountpoint
+	}
+
+	readFile := func(p string) func() string {
+		return func() string {
+			data, _ := ioutil.ReadFile(p)
+			return string(data)
+		}
+	}
+
+	It("updates mounted files", func() {
+		p := mount("dev/nginx/site.conf", nil)
+		Expect(readFile(p)()).To(Equal("v1"))
+
+		Expect(ioutil.WriteFile(filepath.Join(dir, "dev/nginx/site.conf"), []byte("v2"), 0644)).To(Succeed())
+		Eventually(readFile(p), 5*time.Second).Should(Equal("v2"))
+	})
+
+	It("updates mounted folders", func() {
+		p := mount("dev/nginx/htdocs/", nil)
+		Expect(readFile(filepath.Join(p, "index.html"))()).To(Equal("v1"))
+
+		Expect(ioutil.WriteFile(filepath.Join(dir, "dev/nginx/htdocs/index.html"), []byte("v2"), 0644)).To(Succeed())
+		Eventually(readFile(filepath.Join(p, "index.html")), 5*time.Second).Should(Equal("v2"))
+	})
+
+	It("renders templates again when a used key changes", func() {
+		p := mount("dev/nginx/auth.tmpl", map[string]string{"tmpl": "1"})
+		Expect(readFile(p)()).To(Equal("user=admin"))
+
+		Expect(ioutil.WriteFile(filepath.Join(dir, "dev/nginx/user"), []byte("root"), 0644)).To(Succeed())
+		Eventually(readFile(p), 5*time.Second).Should(Equal("user=root"))
+	})
+
+	It("stops updating unmounted volumes", func() {
+		p := mount("dev/nginx/site.conf", nil)
+		Expect(cv.Unmount(&volume.UnmountRequest{Name: "dev/nginx/site.conf", ID: "c1"})).To(Succeed())
+
+		Expect(ioutil.WriteFile(filepath.Join(dir, "dev/nginx/site.conf"), []byte("v2"), 0644)).To(Succeed())
+		Consistently(readFile(p), 2*time.Second).Should(Equal("v1"))
+	})
+})
@@ -0,0 +1,49 @@
+package driver
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+)
+
+// SafeJoin joins the slash separated path rel onto root. Leading and
+// trailing slashes are dropped. Paths with .. elements or without any
+// element are rejected, so the result is always below root
+func SafeJoin(root string, rel string) (string, error) {
+	clean := strings.Trim(rel, "/")
+
+	for _, e := range strings.Split(clean, "/") {
+		if e == ".." {
+			return "", errors.New("Path " + rel + " leaves " + root)
+		}
+	}
+
+	p := filepath.Join(root, filepath.FromSlash(clean))
+	if !isBelow(root, p) {
+		return "", errors.New("Path " + rel + " leaves " + root)
+	}
+
+	return p, nil
+}
+
+// isBelow tells if p is a path below root, not root itself
+func isBelow(root string, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	if err != nil || rel == "." || rel == ".." || filepath.IsAbs(rel) {
+		return false
+	}
+
+	return !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// isReserved tells if an element of rel starts with .., the prefix of the
+// revisions and links of folder volumes
+func isReserved(rel string) bool {
+	for _, e := range strings.Split(strings.Trim(rel, "/"), "/") {
+		if strings.HasPrefix(e, "..") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,106 @@
+package driver_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/axelspringer/docker-conf-volume/driver"
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/sirupsen/logrus"
+)
+
+// traversalSeeds are keys that try to leave a volume
+var traversalSeeds = []string{
+	"a",
+	"a/b/c",
+	"/a//b/",
+	"..",
+	"../a",
+	"a/../../b",
+	"a/./../..",
+	"./.",
+	"/",
+	"..data",
+	"..rev1/a",
+	"....//x",
+	"a/..\\..\\b",
+}
+
+func FuzzSafeJoin(f *testing.F) {
+	for _, seed := range traversalSeeds {
+		f.Add(seed)
+	}
+
+	root := filepath.FromSlash("/var/lib/confvol")
+
+	f.Fuzz(func(t *testing.T, rel string) {
+		p, err := SafeJoin(root, rel)
+		if err != nil {
+			return
+		}
+
+		if !strings.HasPrefix(p, root+string(filepath.Separator)) {
+			t.Fatalf("%q maps to %q outside of %q", rel, p, root)
+		}
+	})
+}
+
+func FuzzFolderKeys(f *testing.F) {
+	for _, seed := range traversalSeeds {
+		f.Add(seed)
+	}
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+
+	f.Fuzz(func(t *testing.T, key string) {
+		parent, err := ioutil.TempDir("", "confvol-fuzz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(parent)
+
+		conf := NewConfiguration()
+		conf.Driver.RootPath = filepath.Join(parent, "root")
+
+		s := &memTreeStore{&memStore{values: map[string]string{"app/" + key: "escaped"}}}
+		cv, err := NewConfigVolume(conf, logger, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := cv.Create(&volume.CreateRequest{Name: "app/"}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := cv.Mount(&volume.MountRequest{Name: "app/", ID: "c1"}); err != nil {
+			t.Fatal(err)
+		}
+		defer cv.Unmount(&volume.UnmountRequest{Name: "app/", ID: "c1"})
+
+		// only the volume and the state file may exist
+		expected := map[string][]string{
+			parent:               {"root"},
+			conf.Driver.RootPath: {"..state", "app"},
+		}
+
+		for dir, names := range expected {
+			entries, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			found := []string{}
+			for _, e := range entries {
+				found = append(found, e.Name())
+			}
+
+			if strings.Join(found, ",") != strings.Join(names, ",") {
+				t.Fatalf("key %q left the volume, %s contains %v", key, dir, found)
+			}
+		}
+	})
+}
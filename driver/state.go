@@ -0,0 +1,441 @@
+package driver
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// stateDir keeps the state of the plugin below the driver root path. Names
+// with the .. prefix are reserved, so no volume can take its place
+const stateDir = "..state"
+
+// stateFile is the volume registry in stateDir
+const stateFile = "volumes.json"
+
+// legacyStateFile is the volume registry of former versions, right below
+// the driver root path
+const legacyStateFile = ".confvol-state.json"
+
+// bootIDPath identifies the current boot of the host
+const bootIDPath = "/proc/sys/kernel/random/boot_id"
+
+// volumeState is the on disk format of the volume registry. BootID tells
+// the boot of the host the mount counts belong to
+type volumeState struct {
+	BootID  string                  `json:"bootid,omitempty"`
+	Volumes map[string]*VolumeMount `json:"volumes"`
+}
+
+// bootID returns the id of the current boot of the host, empty if unknown
+func bootID() string {
+	data, err := ioutil.ReadFile(bootIDPath)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(data))
+}
+
+// statePath returns the path of the state file
+func (v *ConfigVolume) statePath() string {
+	return filepath.Join(v.mountPoint, stateDir, stateFile)
+}
+
+// saveState writes the volume registry, so it survives a restart of the
+// plugin. Errors are logged, the volumes keep working in memory
+func (v *ConfigVolume) saveState() {
+	v.stateM.Lock()
+	defer v.stateM.Unlock()
+
+	// snapshot the registry, without holding the locks while writing
+	v.m.Lock()
+	state := &volumeState{BootID: bootID(), Volumes: map[string]*VolumeMount{}}
+	for name, vm := range v.volumes {
+		vm.m.Lock()
+		snapshot := *vm
+		state.Volumes[name] = &snapshot
+		vm.m.Unlock()
+	}
+	v.m.Unlock()
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		v.logger.Error(err)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(v.statePath()), 0700); err != nil {
+		v.logger.Error(err)
+		return
+	}
+
+	if err := writeFileAtomic(v.statePath(), data, 0600); err != nil {
+		v.logger.Error(err)
+	}
+}
+
+// readState reads the volume registry. The registry of former versions is
+// read if there is no other, legacy tells so
+func (v *ConfigVolume) readState() (state *volumeState, legacy bool, err error) {
+	state = &volumeState{Volumes: map[string]*VolumeMount{}}
+
+	data, err := ioutil.ReadFile(v.statePath())
+	if err == nil {
+		return state, false, json.Unmarshal(data, state)
+	} else if !os.IsNotExist(err) {
+		return nil, false, err
+	}
+
+	data, err = ioutil.ReadFile(filepath.Join(v.mountPoint, legacyStateFile))
+	if os.IsNotExist(err) {
+		return state, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	return state, true, json.Unmarshal(data, &state.Volumes)
+}
+
+// loadState restores the volume registry of a former run and reconciles it
+// with the volumes on disk. Volumes that can't be set up are kept with
+// their data, mounting them fails until they can. The mount counts are reset
+// if the host was restarted, no container holds a mount across a reboot
+func (v *ConfigVolume) loadState() error {
+	state, legacy, err := v.readState()
+	if err != nil {
+		return err
+	}
+
+	current := bootID()
+	rebooted := len(state.BootID) > 0 && len(current) > 0 && state.BootID != current
+
+	for name, s := range state.Volumes {
+		vm, err := v.newVolumeMount(name, s.Options)
+		if err != nil {
+			// a backend may be down or its profile gone for now, keep the
+			// volume and its data. Mounts set it up again
+			v.logger.Errorf("Failed to set up volume %s, mounts will try again: %s", name, err)
+			vm = v.unavailableVolumeMount(name, s, err)
+		}
+
+		if len(s.Root) > 0 && isBelow(v.mountPoint, s.Root) {
+			vm.Root = s.Root
+		}
+		if len(vm.Root) == 0 {
+			v.logger.Errorf("Drop volume %s, it has no path below the root path", name)
+			continue
+		}
+		vm.MountID = s.MountID
+
+		if s.ReferenceCounter > 0 && !rebooted {
+			vm.ReferenceCounter = s.ReferenceCounter
+		} else if s.ReferenceCounter > 0 {
+			v.logger.Infof("Reset the mounts of volume %s, the host was restarted", name)
+		}
+
+		v.volumes[name] = vm
+	}
+
+	// move the registry of a former version out of the volumes
+	if legacy {
+		v.saveState()
+		os.Remove(filepath.Join(v.mountPoint, legacyStateFile))
+	}
+
+	if err := v.reconcile(); err != nil {
+		return err
+	}
+
+	v.logger.Infof("Restored %d volumes", len(v.volumes))
+	v.saveState()
+
+	return nil
+}
+
+// unavailableVolumeMount keeps a volume that failed to set up as it was
+// saved. It can't be synced until retrySetup succeeds
+func (v *ConfigVolume) unavailableVolumeMount(name string, saved *VolumeMount, err error) *VolumeMount {
+	vm := *saved
+	vm.Root = ""
+	if root, err := SafeJoin(v.mountPoint, name); err == nil {
+		vm.Root = root
+	}
+
+	vm.ReferenceCounter = 0
+	vm.name = name
+	vm.m = &sync.Mutex{}
+	vm.syncM = &sync.Mutex{}
+	vm.setupErr = err
+
+	return &vm
+}
+
+// retrySetup sets up a volume that failed to on start with the options it
+// was created with. The new volume takes its place in the registry
+func (v *ConfigVolume) retrySetup(name string, unavailable *VolumeMount) (*VolumeMount, error) {
+	unavailable.m.Lock()
+	saved := *unavailable
+	unavailable.m.Unlock()
+
+	vm, err := v.newVolumeMount(name, saved.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	vm.Root = saved.Root
+	vm.ReferenceCounter = saved.ReferenceCounter
+	vm.MountID = saved.MountID
+
+	v.m.Lock()
+	defer v.m.Unlock()
+
+	current, ok := v.volumes[name]
+	if !ok {
+		return nil, errors.New("Volume " + name + " was removed")
+	}
+
+	// another mount was faster
+	if current != unavailable {
+		return current, nil
+	}
+
+	v.volumes[name] = vm
+	v.logger.Infof("Set up volume %s", name)
+
+	return vm, nil
+}
+
+// reconcile compares the volumes on disk with the registry. Folder volumes
+// on disk without an entry are adopted with default options, files left
+// over by interrupted writes and empty folders are removed. Other files are
+// kept, lookup adopts them by the name docker asks for, as a plain folder
+// of a former version can't be told apart from the parent of file volumes
+func (v *ConfigVolume) reconcile() error {
+	// nothing was created yet
+	if _, err := os.Stat(v.mountPoint); os.IsNotExist(err) {
+		return nil
+	}
+
+	// folder volumes of former versions have no ..data link, the registry
+	// tells them
+	roots := map[string]bool{}
+	for _, vm := range v.volumes {
+		roots[filepath.Clean(vm.Root)] = true
+	}
+
+	_, err := v.reconcileDir(v.mountPoint, roots)
+	return err
+}
+
+// reconcileDir reconciles the entries of dir and tells if anything in it
+// is still in use
+func (v *ConfigVolume) reconcileDir(dir string, roots map[string]bool) (bool, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+
+	used := false
+	for _, e := range entries {
+		p := filepath.Join(dir, e.Name())
+
+		rel, err := filepath.Rel(v.mountPoint, p)
+		if err != nil {
+			return false, err
+		}
+		name := filepath.ToSlash(rel)
+
+		switch {
+		case roots[p], name == stateDir:
+			used = true
+			continue
+		case isLeftover(e.Name()):
+			// left over by an interrupted write
+		case e.IsDir() && isFolderRoot(p):
+			used = v.adopt(name+"/") || used
+			continue
+		case e.IsDir():
+			inUse, err := v.reconcileDir(p, roots)
+			if err != nil {
+				return false, err
+			}
+			if inUse {
+				used = true
+				continue
+			}
+		case e.Mode().IsRegular():
+			used = true
+			continue
+		default:
+			v.logger.Warnf("Keep unknown %s below the root path", p)
+			used = true
+			continue
+		}
+
+		v.logger.Infof("Remove %s, it belongs to no volume", p)
+		if err := os.RemoveAll(p); err != nil {
+			return false, err
+		}
+	}
+
+	return used, nil
+}
+
+// isLeftover tells if name is a temp file of writeFileAtomicAs, named
+// .<base>.tmp<digits>, or a temp link of replaceLink
+func isLeftover(name string) bool {
+	if strings.HasPrefix(name, "..tmp") {
+		return true
+	}
+
+	i := strings.LastIndex(name, ".tmp")
+	if !strings.HasPrefix(name, ".") || i < 2 || i+len(".tmp") == len(name) {
+		return false
+	}
+
+	for _, c := range name[i+len(".tmp"):] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// adopt registers a volume found on disk. Its options are lost, so it
+// syncs from the key of its name
+func (v *ConfigVolume) adopt(name string) bool {
+	vm, err := v.newVolumeMount(name, map[string]string{})
+	if err != nil {
+		v.logger.Errorf("Failed to adopt volume %s: %s", name, err)
+		return true
+	}
+
+	v.logger.Warnf("Adopt volume %s found on disk with default options", name)
+	v.volumes[name] = vm
+
+	return true
+}
+
+// lookup returns a registered volume. Volumes on disk without an entry are
+// adopted by the name docker knows them by, folders if the name ends with a
+// slash or the path is a folder
+func (v *ConfigVolume) lookup(name string) (*VolumeMount, bool) {
+	if vm, ok := v.volume(name); ok {
+		return vm, true
+	}
+
+	p, err := SafeJoin(v.mountPoint, name)
+	if err != nil || isReserved(name) {
+		return nil, false
+	}
+
+	stat, err := os.Lstat(p)
+	if err != nil || !(stat.IsDir() || (stat.Mode().IsRegular() && !strings.HasSuffix(name, "/"))) {
+		return nil, false
+	}
+
+	options := map[string]string{}
+	if stat.IsDir() && !strings.HasSuffix(name, "/") {
+		options["type"] = "dir"
+	}
+
+	vm, err := v.newVolumeMount(name, options)
+	if err != nil {
+		v.logger.Errorf("Failed to adopt volume %s: %s", name, err)
+		return nil, false
+	}
+
+	v.m.Lock()
+	if current, ok := v.volumes[name]; ok {
+		v.m.Unlock()
+		return current, true
+	}
+
+	if _, ok := v.overlapping(vm.Root); ok {
+		v.m.Unlock()
+		return nil, false
+	}
+
+	v.logger.Warnf("Adopt volume %s found on disk with default options", name)
+	v.volumes[name] = vm
+	v.m.Unlock()
+
+	v.saveState()
+	return vm, true
+}
+
+// overlapping returns the name of a volume whose root is p, above p or below
+// it. The registry has to be locked
+func (v *ConfigVolume) overlapping(p string) (string, bool) {
+	for name, vm := range v.volumes {
+		if vm.Root == p || isBelow(vm.Root, p) || isBelow(p, vm.Root) {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+// nestedRoots returns the roots of the other volumes below the root of vm.
+// Former versions allowed them, a sync of vm must leave them alone
+func (v *ConfigVolume) nestedRoots(vm *VolumeMount) []string {
+	v.m.Lock()
+	defer v.m.Unlock()
+
+	nested := []string{}
+	for _, other := range v.volumes {
+		if isBelow(vm.Root, other.Root) {
+			nested = append(nested, other.Root)
+		}
+	}
+
+	return nested
+}
+
+// isFolderRoot tells if p is the root of a folder volume
+func isFolderRoot(p string) bool {
+	stat, err := os.Lstat(filepath.Join(p, dataLink))
+	return err == nil && stat.Mode()&os.ModeSymlink != 0
+}
+
+// restoreMounts syncs and watches the volumes that were mounted before the
+// restart. It runs in the background, so a slow backend doesn't delay the
+// start of the plugin
+func (v *ConfigVolume) restoreMounts() {
+	v.m.Lock()
+	defer v.m.Unlock()
+
+	for name, vm := range v.volumes {
+		if vm.ReferenceCounter > 0 && vm.setupErr == nil {
+			go v.restoreMount(name, vm)
+		}
+	}
+}
+
+// restoreMount syncs and watches a volume mounted before the restart,
+// unless it was unmounted in the meantime
+func (v *ConfigVolume) restoreMount(name string, vm *VolumeMount) {
+	vm.m.Lock()
+	mounted := vm.ReferenceCounter > 0
+	vm.m.Unlock()
+
+	if !mounted {
+		return
+	}
+
+	if _, err := os.Stat(vm.Root); os.IsNotExist(err) {
+		v.logger.Warnf("Mount point of volume %s is gone, sync it again", name)
+	}
+
+	v.startWatch(vm)
+
+	if err := v.syncVolume(name, vm); err != nil {
+		v.logger.Errorf("Failed to restore volume %s: %s", name, err)
+	}
+}
@@ -1,64 +1,402 @@
 package driver
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
-	"github.com/sirupsen/logrus"
 	"github.com/docker/libkv"
 	"github.com/docker/libkv/store"
-	"github.com/docker/libkv/store/etcd"
+	"github.com/docker/libkv/store/consul"
+	"github.com/sirupsen/logrus"
 )
 
-type StoreKVPair = store.KVPair
+// StoreKVPair is an entry of a store. Folders have IsDir set, no value and
+// a key with a trailing slash when they are listed
+type StoreKVPair struct {
+	Key       string
+	Value     []byte
+	LastIndex uint64
+	IsDir     bool
+}
 
-// Store interface
+// fromLibKV converts a libkv entry. Consul has no folders of
+// its own, keys with a trailing slash are taken as folders
+func fromLibKV(pair *store.KVPair) *StoreKVPair {
+	return &StoreKVPair{
+		Key:       pair.Key,
+		Value:     pair.Value,
+		LastIndex: pair.LastIndex,
+		IsDir:     strings.HasSuffix(pair.Key, "/"),
+	}
+}
+
+// fromLibKVList converts a list of libkv entries
+func fromLibKVList(pairs []*store.KVPair) []*StoreKVPair {
+	entries := []*StoreKVPair{}
+	for _, pair := range pairs {
+		entries = append(entries, fromLibKV(pair))
+	}
+
+	return entries
+}
+
+// Store interface. Watch emits the entry of key and WatchTree the listing
+// of key once and again on every change, until stopCh is closed
 type Store interface {
 	Get(key string) (*StoreKVPair, error)
-	List(key string) ([]*StoreKVPair, error)	
+	List(key string) ([]*StoreKVPair, error)
+	Watch(key string, stopCh <-chan struct{}) (<-chan *StoreKVPair, error)
+	WatchTree(key string, stopCh <-chan struct{}) (<-chan []*StoreKVPair, error)
 }
 
 // LibKVStore helper struct
 type LibKVStore struct {
-	Client store.Store
-	logger *logrus.Logger
+	Client       store.Store
+	pollInterval time.Duration
+	logger       *logrus.Logger
+}
+
+// Importer is a store that can be filled from a local directory tree
+type Importer interface {
+	Import(dir string) error
+}
+
+// Purger is a store that keeps copies of entries, like the disk cache.
+// Purge drops the copies of key and of the keys below it
+type Purger interface {
+	Purge(key string) error
+}
+
+// purge drops the copies a store keeps of key, if it keeps any
+func purge(s Store, key string) error {
+	if p, ok := s.(Purger); ok {
+		return p.Purge(key)
+	}
+
+	return nil
+}
+
+// TreeLister is a store that fetches all entries below a key, values
+// included, in a single call. Keys are full keys, folders have IsDir set
+type TreeLister interface {
+	ListTree(key string) ([]*StoreKVPair, error)
+}
+
+// listTree fetches all entries below key. Stores without bulk fetch are
+// walked folder by folder
+func listTree(s Store, key string) ([]*StoreKVPair, error) {
+	if t, ok := s.(TreeLister); ok {
+		return t.ListTree(key)
+	}
+
+	entries := []*StoreKVPair{}
+	if err := walkTree(s, key, func(e *StoreKVPair) { entries = append(entries, e) }); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
 }
 
 // Get a kv entry by key
 func (s *LibKVStore) Get(key string) (*StoreKVPair, error) {
 	kv := s.Client
-	return kv.Get(key)
+	pair, err := kv.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return fromLibKV(pair), nil
 }
 
 // List kv entries by key
 func (s *LibKVStore) List(key string) ([]*StoreKVPair, error) {
 	kv := s.Client
-	return kv.List(key)
+	pairs, err := kv.List(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// consul lists recursive
+	entries := folderChildren(key, fromLibKVList(pairs))
+	if len(entries) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+
+	return entries, nil
+}
+
+// ListTree fetches all kv entries below key. consul lists recursive, so
+// this is a single call
+func (s *LibKVStore) ListTree(key string) ([]*StoreKVPair, error) {
+	pairs, err := s.Client.List(key)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := treeBelow(key, fromLibKVList(pairs))
+	if len(entries) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+
+	return entries, nil
+}
+
+// Watch a kv entry by key. Backends without watch support are polled
+func (s *LibKVStore) Watch(key string, stopCh <-chan struct{}) (<-chan *StoreKVPair, error) {
+	ch, err := s.Client.Watch(key, stopCh)
+	if err == store.ErrCallNotSupported {
+		return pollWatch(s, key, s.pollInterval, stopCh)
+	} else if err != nil {
+		return nil, err
+	}
+
+	// consul sends nothing for a missing key, report it as empty first so
+	// the watch is ready and picks up the key once it is created
+	_, err = s.Client.Get(key)
+	missing := err == store.ErrKeyNotFound
+
+	entries := make(chan *StoreKVPair)
+	go func() {
+		defer close(entries)
+		if missing {
+			select {
+			case entries <- &StoreKVPair{Key: key}:
+			case <-stopCh:
+				return
+			}
+		}
+
+		for pair := range ch {
+			select {
+			case entries <- fromLibKV(pair):
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+
+	return entries, nil
+}
+
+// WatchTree watches kv entries by key. Backends without watch support are polled
+func (s *LibKVStore) WatchTree(key string, stopCh <-chan struct{}) (<-chan []*StoreKVPair, error) {
+	ch, err := s.Client.WatchTree(key, stopCh)
+	if err == store.ErrCallNotSupported {
+		return pollWatchTree(s, key, s.pollInterval, stopCh)
+	} else if err != nil {
+		return nil, err
+	}
+
+	// consul emits the recursive listing
+	children := make(chan []*StoreKVPair)
+	go func() {
+		defer close(children)
+		for pairs := range ch {
+			select {
+			case children <- folderChildren(key, fromLibKVList(pairs)):
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+
+	return children, nil
+}
+
+// Import writes every file below dir to the key of its relative path
+func (s *LibKVStore) Import(dir string) error {
+	return importTree(dir, s.logger, func(key string, data []byte) error {
+		return s.Client.Put(key, data, nil)
+	})
+}
+
+// importTree puts every file below dir to the key of its relative path,
+// with slashes and without a leading one. Files and folders with a reserved
+// name are skipped, no volume could sync them
+func importTree(dir string, logger *logrus.Logger, put func(key string, data []byte) error) error {
+	return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		key := filepath.ToSlash(rel)
+		if isReserved(key) {
+			logger.Warnf("Skip %s, names with the .. prefix are reserved", key)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		logger.Debugf("Import %s", key)
+		return put(key, data)
+	})
+}
+
+// folderChildren reduces a recursive listing to the direct children of key.
+// Nested entries are collapsed to their folder, marked by a trailing slash
+func folderChildren(key string, entries []*StoreKVPair) []*StoreKVPair {
+	prefix := strings.TrimPrefix(key, "/")
+	if len(prefix) > 0 && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	children := []*StoreKVPair{}
+	folders := map[string]bool{}
+
+	for _, pair := range entries {
+		k := strings.TrimPrefix(pair.Key, "/")
+		if !strings.HasPrefix(k, prefix) || k == prefix {
+			continue
+		}
+
+		rest := k[len(prefix):]
+		if i := strings.Index(rest, "/"); i >= 0 {
+			folder := pair.Key[:len(pair.Key)-len(rest)+i+1]
+			if !folders[folder] {
+				folders[folder] = true
+				children = append(children, &StoreKVPair{Key: folder, IsDir: true})
+			}
+			continue
+		}
+
+		children = append(children, pair)
+	}
+
+	return children
+}
+
+// treeBelow drops the entries of a prefix listing that are not below key,
+// like key itself or siblings sharing its prefix
+func treeBelow(key string, entries []*StoreKVPair) []*StoreKVPair {
+	below := []*StoreKVPair{}
+	for _, e := range entries {
+		if _, ok := relativeKey(key, e.Key); ok {
+			below = append(below, e)
+		}
+	}
+
+	return below
+}
+
+// relativeKey returns the path of key below folder, false if key is not
+// below folder
+func relativeKey(folder string, key string) (string, bool) {
+	prefix := strings.Trim(folder, "/")
+	k := strings.Trim(key, "/")
+
+	if len(prefix) > 0 {
+		if !strings.HasPrefix(k, prefix+"/") {
+			return "", false
+		}
+		k = k[len(prefix)+1:]
+	}
+
+	return k, len(k) > 0
 }
 
 // NewStore creates a new store. suprise ..
 func NewStore(c *Configuration, logger *logrus.Logger) (Store, error) {
-	s := &LibKVStore{}
+	s, err := newBackendStore(c, logger)
+	if err != nil || len(c.Cache.Path) == 0 || !isCachedBackend(c) {
+		return s, err
+	}
+
+	return NewCachedStore(s, filepath.Join(c.Cache.Path, cacheName(&c.Backend)), time.Duration(c.Cache.MaxAge)*time.Second, logger)
+}
+
+// isCachedBackend checks if a backend is reached over the network and
+// therefore worth caching. Vault secrets are only written to disk if
+// cache.secrets is set
+func isCachedBackend(c *Configuration) bool {
+	switch c.Backend.Type {
+	case "etcd", "etcd3", "consul":
+		return true
+	case "vault":
+		return c.Cache.Secrets
+	}
 
-	// Initialize a new store with etcd
+	return false
+}
+
+// cacheName derives a stable cache directory name from the backend location
+func cacheName(b *BackendSettings) string {
+	h := sha256.Sum256([]byte(strings.Join([]string{b.Type, b.Endpoints, b.Vault.Mount, b.Vault.Field}, "\x00")))
+	return b.Type + "-" + hex.EncodeToString(h[:8])
+}
+
+// newBackendStore creates the store of the configured backend type
+func newBackendStore(c *Configuration, logger *logrus.Logger) (Store, error) {
+	switch c.Backend.Type {
+	case "etcd":
+		return NewEtcdStore(c, logger)
+	case "etcd3":
+		return NewEtcd3Store(c, logger)
+	case "boltdb":
+		return NewBoltStore(c, logger)
+	case "file":
+		return NewFileStore(c, logger)
+	case "git":
+		return NewGitStore(c, logger)
+	case "vault":
+		return NewVaultStore(c, logger)
+	case "overlay":
+		return NewOverlayStore(c, logger)
+	}
+
+	return NewLibKVStore(c, logger)
+}
+
+// NewLibKVStore creates a new store backed by libkv
+func NewLibKVStore(c *Configuration, logger *logrus.Logger) (Store, error) {
+	endpoints := c.GetBackendEndpointList()
+	config := &store.Config{
+		ConnectionTimeout: 10 * time.Second,
+	}
+
+	// Initialize a new store with consul
 	kv, err := libkv.NewStore(
 		store.Backend(c.Backend.Type),
-		c.GetBackendEndpointList(),
-		&store.Config{
-			ConnectionTimeout: 10 * time.Second,
-		},
+		endpoints,
+		config,
 	)
 
 	if err != nil {
 		return nil, err
 	}
 
-	s.Client = kv
-	s.logger = logger
+	return NewLibKVStoreFromClient(kv, c, logger), nil
+}
 
-	return s, nil
+// NewLibKVStoreFromClient creates a new store on top of a libkv client
+func NewLibKVStoreFromClient(client store.Store, c *Configuration, logger *logrus.Logger) *LibKVStore {
+	return &LibKVStore{
+		Client:       client,
+		pollInterval: time.Duration(c.Backend.PollInterval) * time.Second,
+		logger:       logger,
+	}
 }
 
 // register the backend(s)
 func init() {
-	etcd.Register()
+	consul.Register()
 }
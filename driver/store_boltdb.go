@@ -0,0 +1,194 @@
+package driver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltIndexLen is the length of the index in front of every value. The
+// layout is the one of libkv, so databases written by it stay readable
+const boltIndexLen = 8
+
+// BoltStore serves keys from an embedded bbolt database file. The file is
+// opened for every call, so --import can fill it while the plugin runs
+type BoltStore struct {
+	Path         string
+	Bucket       []byte
+	timeout      time.Duration
+	pollInterval time.Duration
+	logger       *logrus.Logger
+	m            sync.Mutex
+}
+
+// open the database and run fn in a read or write transaction
+func (s *BoltStore) open(write bool, fn func(tx *bolt.Tx) error) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	db, err := bolt.Open(s.Path, 0644, &bolt.Options{Timeout: s.timeout})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if write {
+		return db.Update(fn)
+	}
+
+	return db.View(fn)
+}
+
+// normalize the key. Keys are stored without a leading slash
+func (s *BoltStore) normalize(key string) string {
+	return strings.TrimPrefix(key, "/")
+}
+
+// entry converts a stored value, the index is cut off
+func (s *BoltStore) entry(key []byte, v []byte) *StoreKVPair {
+	e := &StoreKVPair{Key: string(key), IsDir: bytes.HasSuffix(key, []byte("/"))}
+	if len(v) >= boltIndexLen {
+		e.LastIndex = binary.LittleEndian.Uint64(v[:boltIndexLen])
+		e.Value = append([]byte{}, v[boltIndexLen:]...)
+	}
+
+	return e
+}
+
+// scan returns all entries with the key prefix
+func (s *BoltStore) scan(prefix string) ([]*StoreKVPair, error) {
+	entries := []*StoreKVPair{}
+
+	err := s.open(false, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(s.Bucket)
+		if bucket == nil {
+			return store.ErrKeyNotFound
+		}
+
+		c := bucket.Cursor()
+		for k, v := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, v = c.Next() {
+			entries = append(entries, s.entry(k, v))
+		}
+
+		return nil
+	})
+
+	return entries, err
+}
+
+// Get a kv entry by key
+func (s *BoltStore) Get(key string) (*StoreKVPair, error) {
+	var e *StoreKVPair
+
+	err := s.open(false, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(s.Bucket)
+		if bucket == nil {
+			return store.ErrKeyNotFound
+		}
+
+		v := bucket.Get([]byte(s.normalize(key)))
+		if len(v) == 0 {
+			return store.ErrKeyNotFound
+		}
+
+		e = s.entry([]byte(s.normalize(key)), v)
+		return nil
+	})
+
+	return e, err
+}
+
+// List kv entries by key. Nested keys are listed as folders with a
+// trailing slash
+func (s *BoltStore) List(key string) ([]*StoreKVPair, error) {
+	entries, err := s.scan(s.normalize(key))
+	if err != nil {
+		return nil, err
+	}
+
+	children := folderChildren(key, entries)
+	if len(children) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+
+	return children, nil
+}
+
+// ListTree fetches all kv entries below key in a single transaction
+func (s *BoltStore) ListTree(key string) ([]*StoreKVPair, error) {
+	entries, err := s.scan(s.normalize(key))
+	if err != nil {
+		return nil, err
+	}
+
+	entries = treeBelow(key, entries)
+	if len(entries) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+
+	return entries, nil
+}
+
+// Watch polls a key for changes
+func (s *BoltStore) Watch(key string, stopCh <-chan struct{}) (<-chan *StoreKVPair, error) {
+	return pollWatch(s, key, s.pollInterval, stopCh)
+}
+
+// WatchTree polls a folder for changes
+func (s *BoltStore) WatchTree(key string, stopCh <-chan struct{}) (<-chan []*StoreKVPair, error) {
+	return pollWatchTree(s, key, s.pollInterval, stopCh)
+}
+
+// Import writes every file below dir to the key of its relative path, all
+// in a single transaction
+func (s *BoltStore) Import(dir string) error {
+	return s.open(true, func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(s.Bucket)
+		if err != nil {
+			return err
+		}
+
+		return importTree(dir, s.logger, func(key string, data []byte) error {
+			index, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+
+			v := make([]byte, boltIndexLen, boltIndexLen+len(data))
+			binary.LittleEndian.PutUint64(v, index)
+
+			return bucket.Put([]byte(key), append(v, data...))
+		})
+	})
+}
+
+// NewBoltStore creates a new store on top of a bbolt database file. The
+// file and its directory are created if missing
+func NewBoltStore(c *Configuration, logger *logrus.Logger) (Store, error) {
+	if err := os.MkdirAll(filepath.Dir(c.Backend.Path), 0750); err != nil {
+		return nil, err
+	}
+
+	s := &BoltStore{
+		Path:         c.Backend.Path,
+		Bucket:       []byte(c.Backend.Bucket),
+		timeout:      10 * time.Second,
+		pollInterval: time.Duration(c.Backend.PollInterval) * time.Second,
+		logger:       logger,
+	}
+
+	// fail early on files that are no database
+	if err := s.open(false, func(tx *bolt.Tx) error { return nil }); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
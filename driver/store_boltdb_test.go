@@ -0,0 +1,88 @@
+package driver_test
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/axelspringer/docker-conf-volume/driver"
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BoltDB store", func() {
+	var (
+		dir  string
+		conf *Configuration
+		s    Store
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "confvol-boltdb")
+		Expect(err).To(BeNil())
+
+		conf = NewConfiguration()
+		conf.Backend.Type = "boltdb"
+		conf.Backend.Path = filepath.Join(dir, "confvol.db")
+
+		s, err = NewStore(conf, logrus.New())
+		Expect(err).To(BeNil())
+		Expect(s.(Importer).Import("../examples/etcd_datatree")).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("serves imported values", func() {
+		entry, err := s.Get("dev/auth/mysql/root")
+		Expect(err).To(BeNil())
+		Expect(string(entry.Value)).To(Equal("S3cR37"))
+
+		entry, err = s.Get("/dev/auth/mysql/root")
+		Expect(err).To(BeNil())
+		Expect(string(entry.Value)).To(Equal("S3cR37"))
+	})
+
+	It("lists direct children with folders", func() {
+		entries, err := s.List("/dev/nginx/etc/nginx/")
+		Expect(err).To(BeNil())
+
+		keys := []string{}
+		for _, e := range entries {
+			keys = append(keys, e.Key)
+		}
+
+		Expect(keys).To(ConsistOf("dev/nginx/etc/nginx/.htpasswd", "dev/nginx/etc/nginx/conf.d/"))
+	})
+
+	It("does not list siblings sharing the prefix", func() {
+		entries, err := s.List("dev/auth/nginx/user")
+		Expect(err).NotTo(BeNil())
+		Expect(entries).To(BeEmpty())
+	})
+
+	It("reads databases written by libkv", func() {
+		db, err := bolt.Open(conf.Backend.Path, 0644, nil)
+		Expect(err).To(BeNil())
+
+		// libkv puts an index in front of every value
+		v := make([]byte, 8)
+		binary.LittleEndian.PutUint64(v, 42)
+
+		Expect(db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte(conf.Backend.Bucket)).Put([]byte("dev/libkv"), append(v, "value"...))
+		})).To(Succeed())
+		Expect(db.Close()).To(Succeed())
+
+		entry, err := s.Get("/dev/libkv")
+		Expect(err).To(BeNil())
+		Expect(string(entry.Value)).To(Equal("value"))
+		Expect(entry.LastIndex).To(Equal(uint64(42)))
+	})
+})
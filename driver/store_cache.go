@@ -0,0 +1,204 @@
+package driver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/sirupsen/logrus"
+)
+
+// CachedStore keeps the last successfully fetched values of a store on disk
+// and serves them while the store is not reachable
+type CachedStore struct {
+	Store
+	dir    string
+	maxAge time.Duration
+	logger *logrus.Logger
+}
+
+// cacheEntry is the on disk format of a cached response
+type cacheEntry struct {
+	Key     string         `json:"key"`
+	Fetched time.Time      `json:"fetched"`
+	Entries []*StoreKVPair `json:"entries"`
+}
+
+// path of the cache file of a request
+func (s *CachedStore) path(op string, key string) string {
+	h := sha256.Sum256([]byte(op + "\x00" + key))
+	return filepath.Join(s.dir, op+"-"+hex.EncodeToString(h[:]))
+}
+
+// save a response to the cache
+func (s *CachedStore) save(op string, key string, entries []*StoreKVPair) {
+	data, err := json.Marshal(&cacheEntry{Key: key, Fetched: time.Now(), Entries: entries})
+	if err != nil {
+		s.logger.Error(err)
+		return
+	}
+
+	if err := writeFileAtomic(s.path(op, key), data, 0600); err != nil {
+		s.logger.Error(err)
+	}
+}
+
+// load a response from the cache. Entries older than maxAge are ignored
+func (s *CachedStore) load(op string, key string) (*cacheEntry, bool) {
+	data, err := ioutil.ReadFile(s.path(op, key))
+	if err != nil {
+		return nil, false
+	}
+
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		s.logger.Error(err)
+		return nil, false
+	}
+
+	if s.maxAge > 0 && time.Since(entry.Fetched) > s.maxAge {
+		s.logger.Warnf("Cached %s %s is older than %s, not serving it", op, key, s.maxAge)
+		return nil, false
+	}
+
+	return entry, true
+}
+
+// forget a response that no longer exists in the store
+func (s *CachedStore) forget(op string, key string) {
+	os.Remove(s.path(op, key))
+}
+
+// Purge removes the cached responses of key and of the keys below it
+func (s *CachedStore) Purge(key string) error {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		p := filepath.Join(s.dir, f.Name())
+
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			continue
+		}
+
+		entry := &cacheEntry{}
+		if err := json.Unmarshal(data, entry); err != nil {
+			continue
+		}
+
+		if _, below := relativeKey(key, entry.Key); below || strings.Trim(entry.Key, "/") == strings.Trim(key, "/") {
+			s.logger.Debugf("Purge cached %s", entry.Key)
+			if err := os.Remove(p); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Get a kv entry by key, from the cache if the store fails
+func (s *CachedStore) Get(key string) (*StoreKVPair, error) {
+	entry, err := s.Store.Get(key)
+	switch {
+	case err == nil:
+		s.save("get", key, []*StoreKVPair{entry})
+		return entry, nil
+	case err == store.ErrKeyNotFound:
+		s.forget("get", key)
+		return nil, err
+	}
+
+	cached, ok := s.load("get", key)
+	if !ok || len(cached.Entries) != 1 {
+		return nil, err
+	}
+
+	s.logger.Warnf("Serving stale %s from %s: %s", key, cached.Fetched.Format(time.RFC3339), err)
+	return cached.Entries[0], nil
+}
+
+// List kv entries by key, from the cache if the store fails
+func (s *CachedStore) List(key string) ([]*StoreKVPair, error) {
+	return s.list("list", key, s.Store.List)
+}
+
+// ListTree fetches all kv entries below key, from the cache if the store
+// fails
+func (s *CachedStore) ListTree(key string) ([]*StoreKVPair, error) {
+	return s.list("tree", key, func(key string) ([]*StoreKVPair, error) {
+		return listTree(s.Store, key)
+	})
+}
+
+// list fetches entries and caches them as op
+func (s *CachedStore) list(op string, key string, fetch func(string) ([]*StoreKVPair, error)) ([]*StoreKVPair, error) {
+	entries, err := fetch(key)
+	switch {
+	case err == nil:
+		s.save(op, key, entries)
+		return entries, nil
+	case err == store.ErrKeyNotFound:
+		s.forget(op, key)
+		return nil, err
+	}
+
+	cached, ok := s.load(op, key)
+	if !ok {
+		return nil, err
+	}
+
+	s.logger.Warnf("Serving stale %s of %s from %s: %s", op, key, cached.Fetched.Format(time.RFC3339), err)
+	return cached.Entries, nil
+}
+
+// Import fills the wrapped store, if it supports imports
+func (s *CachedStore) Import(dir string) error {
+	importer, ok := s.Store.(Importer)
+	if !ok {
+		return errors.New("The backend does not support imports")
+	}
+
+	return importer.Import(dir)
+}
+
+// AtRevision pins the wrapped store to a revision, if it supports that. The
+// pinned store is cached on its own
+func (s *CachedStore) AtRevision(rev string) (Store, error) {
+	rs, ok := s.Store.(RevisionStore)
+	if !ok {
+		return nil, errors.New("The backend does not support the ref option")
+	}
+
+	pinned, err := rs.AtRevision(rev)
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.Sum256([]byte(rev))
+	return NewCachedStore(pinned, filepath.Join(s.dir, "ref-"+hex.EncodeToString(h[:8])), s.maxAge, s.logger)
+}
+
+// NewCachedStore wraps a store with a disk cache below dir
+func NewCachedStore(s Store, dir string, maxAge time.Duration, logger *logrus.Logger) (*CachedStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &CachedStore{
+		Store:  s,
+		dir:    dir,
+		maxAge: maxAge,
+		logger: logger,
+	}, nil
+}
@@ -0,0 +1,238 @@
+package driver_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/axelspringer/docker-conf-volume/driver"
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/docker/libkv/store"
+	"github.com/sirupsen/logrus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CachedStore", func() {
+	var (
+		dir  string
+		mock *StoreMock
+		down bool
+	)
+
+	errDown := errors.New("connection refused")
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "confvol-cache")
+		Expect(err).To(BeNil())
+
+		down = false
+		mock = newStoreMock(&map[string]string{"app/a": "1", "app/b": "2"})
+		mock.get = func(key string) (*StoreKVPair, error) {
+			if down {
+				return nil, errDown
+			}
+			if v, ok := mock.kvMap[key]; ok {
+				return &StoreKVPair{Key: key, Value: []byte(v)}, nil
+			}
+			return nil, store.ErrKeyNotFound
+		}
+		mock.list = func(key string) ([]*StoreKVPair, error) {
+			if down {
+				return nil, errDown
+			}
+			return []*StoreKVPair{{Key: "app/a", Value: []byte(mock.kvMap["app/a"])}}, nil
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("serves the last known values while the backend is down", func() {
+		s, err := NewCachedStore(mock, dir, 0, logrus.New())
+		Expect(err).To(BeNil())
+
+		_, err = s.Get("app/a")
+		Expect(err).To(BeNil())
+		_, err = s.List("app/")
+		Expect(err).To(BeNil())
+
+		mock.kvMap["app/a"] = "changed"
+		down = true
+
+		entry, err := s.Get("app/a")
+		Expect(err).To(BeNil())
+		Expect(string(entry.Value)).To(Equal("1"))
+
+		entries, err := s.List("app/")
+		Expect(err).To(BeNil())
+		Expect(entries).To(HaveLen(1))
+		Expect(string(entries[0].Value)).To(Equal("1"))
+	})
+
+	It("fails if nothing is cached", func() {
+		s, err := NewCachedStore(mock, dir, 0, logrus.New())
+		Expect(err).To(BeNil())
+
+		down = true
+		_, err = s.Get("app/b")
+		Expect(err).To(MatchError(errDown))
+	})
+
+	It("does not serve values older than the staleness limit", func() {
+		s, err := NewCachedStore(mock, dir, time.Nanosecond, logrus.New())
+		Expect(err).To(BeNil())
+
+		_, err = s.Get("app/a")
+		Expect(err).To(BeNil())
+
+		down = true
+		time.Sleep(time.Millisecond)
+		_, err = s.Get("app/a")
+		Expect(err).To(MatchError(errDown))
+	})
+
+	It("forgets deleted keys", func() {
+		s, err := NewCachedStore(mock, dir, 0, logrus.New())
+		Expect(err).To(BeNil())
+
+		_, err = s.Get("app/b")
+		Expect(err).To(BeNil())
+
+		delete(mock.kvMap, "app/b")
+		_, err = s.Get("app/b")
+		Expect(err).To(Equal(store.ErrKeyNotFound))
+
+		down = true
+		_, err = s.Get("app/b")
+		Expect(err).To(MatchError(errDown))
+	})
+
+	It("purges the cached responses of a key and below", func() {
+		s, err := NewCachedStore(mock, dir, 0, logrus.New())
+		Expect(err).To(BeNil())
+
+		_, err = s.Get("app/a")
+		Expect(err).To(BeNil())
+		_, err = s.Get("app/b")
+		Expect(err).To(BeNil())
+		_, err = s.List("app/")
+		Expect(err).To(BeNil())
+
+		Expect(s.Purge("app/a")).To(Succeed())
+
+		down = true
+		_, err = s.Get("app/a")
+		Expect(err).To(MatchError(errDown))
+		_, err = s.Get("app/b")
+		Expect(err).To(BeNil())
+
+		Expect(s.Purge("app")).To(Succeed())
+		_, err = s.Get("app/b")
+		Expect(err).To(MatchError(errDown))
+		_, err = s.List("app/")
+		Expect(err).To(MatchError(errDown))
+	})
+
+	It("purges the keys of removed volumes that no other volume reads", func() {
+		s, err := NewCachedStore(mock, filepath.Join(dir, "cache"), 0, logrus.New())
+		Expect(err).To(BeNil())
+
+		conf := NewConfiguration()
+		conf.Driver.RootPath = filepath.Join(dir, "root")
+		Expect(os.MkdirAll(conf.Driver.RootPath, 0755)).To(Succeed())
+
+		cv, err := NewConfigVolume(conf, logrus.New(), s)
+		Expect(err).To(BeNil())
+
+		for _, name := range []string{"app/a", "app/b", "copy"} {
+			options := map[string]string{}
+			if name == "copy" {
+				options["key"] = "app/b"
+			}
+
+			Expect(cv.Create(&volume.CreateRequest{Name: name, Options: options})).To(Succeed())
+			_, err := cv.Mount(&volume.MountRequest{Name: name, ID: "c1"})
+			Expect(err).To(BeNil())
+			Expect(cv.Unmount(&volume.UnmountRequest{Name: name, ID: "c1"})).To(Succeed())
+		}
+
+		Expect(cv.Remove(&volume.RemoveRequest{Name: "app/a"})).To(Succeed())
+		Expect(cv.Remove(&volume.RemoveRequest{Name: "app/b"})).To(Succeed())
+
+		down = true
+		_, err = s.Get("app/a")
+		Expect(err).To(MatchError(errDown))
+
+		// still read by the volume copy
+		_, err = s.Get("app/b")
+		Expect(err).To(BeNil())
+	})
+
+	It("imports into the wrapped store", func() {
+		client := newConsulMock(nil)
+		s, err := NewCachedStore(NewLibKVStoreFromClient(client, NewConfiguration(), logrus.New()), dir, 0, logrus.New())
+		Expect(err).To(BeNil())
+
+		var importer Importer = s
+		Expect(importer.Import("../examples/etcd_datatree")).To(Succeed())
+
+		entry, err := s.Get("dev/auth/mysql/root")
+		Expect(err).To(BeNil())
+		Expect(string(entry.Value)).To(Equal("S3cR37"))
+
+		// stores without imports still fail
+		s, err = NewCachedStore(mock, dir, 0, logrus.New())
+		Expect(err).To(BeNil())
+		Expect(s.Import("../examples/etcd_datatree")).To(MatchError("The backend does not support imports"))
+	})
+
+	It("forwards revisions of the wrapped store", func() {
+		s, err := NewCachedStore(mock, dir, 0, logrus.New())
+		Expect(err).To(BeNil())
+
+		var rs RevisionStore = s
+		_, err = rs.AtRevision("v1")
+		Expect(err).To(MatchError("The backend does not support the ref option"))
+	})
+
+	It("only caches vault secrets if enabled", func() {
+		conf := NewConfiguration()
+		conf.Cache.Path = dir
+		conf.Backend.Type = "vault"
+		conf.Backend.Endpoints = "127.0.0.1:1"
+		conf.Backend.Vault.Token = "s.token"
+
+		s, err := NewStore(conf, logrus.New())
+		Expect(err).To(BeNil())
+		Expect(s).To(BeAssignableToTypeOf(&VaultStore{}))
+
+		conf.Cache.Secrets = true
+		s, err = NewStore(conf, logrus.New())
+		Expect(err).To(BeNil())
+		Expect(s).To(BeAssignableToTypeOf(&CachedStore{}))
+	})
+
+	It("only caches remote backends", func() {
+		conf := NewConfiguration()
+		conf.Cache.Path = dir
+		conf.Backend.Type = "file"
+		conf.Backend.Path = "../examples/etcd_datatree"
+
+		s, err := NewStore(conf, logrus.New())
+		Expect(err).To(BeNil())
+		Expect(s).To(BeAssignableToTypeOf(&FileStore{}))
+
+		conf.Backend.Type = "etcd3"
+		conf.Backend.Endpoints = "127.0.0.1:1"
+
+		s, err = NewStore(conf, logrus.New())
+		Expect(err).To(BeNil())
+		Expect(s).To(BeAssignableToTypeOf(&CachedStore{}))
+	})
+})
@@ -0,0 +1,254 @@
+package driver
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	etcd "github.com/coreos/etcd/client"
+	"github.com/docker/libkv/store"
+	"github.com/sirupsen/logrus"
+)
+
+// EtcdStore talks to etcd through the v2 keys api, which knows real
+// directories
+type EtcdStore struct {
+	Client  etcd.KeysAPI
+	timeout time.Duration
+	logger  *logrus.Logger
+}
+
+// entry converts an etcd node. Directories get a trailing slash
+func (s *EtcdStore) entry(key string, n *etcd.Node) *StoreKVPair {
+	if n.Dir && !strings.HasSuffix(key, "/") {
+		key += "/"
+	}
+
+	return &StoreKVPair{
+		Key:       key,
+		Value:     []byte(n.Value),
+		LastIndex: n.ModifiedIndex,
+		IsDir:     n.Dir,
+	}
+}
+
+// get a node, mapping missing keys to store.ErrKeyNotFound
+func (s *EtcdStore) get(key string, recursive bool) (*etcd.Response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	resp, err := s.Client.Get(ctx, key, &etcd.GetOptions{Quorum: true, Sort: true, Recursive: recursive})
+	if etcd.IsKeyNotFound(err) {
+		return nil, store.ErrKeyNotFound
+	}
+
+	return resp, err
+}
+
+// Get a kv entry by key
+func (s *EtcdStore) Get(key string) (*StoreKVPair, error) {
+	resp, err := s.get(key, false)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := s.entry(resp.Node.Key, resp.Node)
+	entry.Key = key
+
+	return entry, nil
+}
+
+// List the direct children of a directory
+func (s *EtcdStore) List(key string) ([]*StoreKVPair, error) {
+	resp, err := s.get(key, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// values have no children
+	if !resp.Node.Dir {
+		return nil, store.ErrKeyNotFound
+	}
+
+	entries := []*StoreKVPair{}
+	for _, n := range resp.Node.Nodes {
+		entries = append(entries, s.entry(n.Key, n))
+	}
+
+	return entries, nil
+}
+
+// ListTree fetches a directory with all of its descendants in one request
+func (s *EtcdStore) ListTree(key string) ([]*StoreKVPair, error) {
+	resp, err := s.get(key, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if !resp.Node.Dir {
+		return nil, store.ErrKeyNotFound
+	}
+
+	entries := []*StoreKVPair{}
+
+	var flatten func(nodes etcd.Nodes)
+	flatten = func(nodes etcd.Nodes) {
+		for _, n := range nodes {
+			entries = append(entries, s.entry(n.Key, n))
+			flatten(n.Nodes)
+		}
+	}
+	flatten(resp.Node.Nodes)
+
+	return entries, nil
+}
+
+// Watch a kv entry by key through an etcd watcher
+func (s *EtcdStore) Watch(key string, stopCh <-chan struct{}) (<-chan *StoreKVPair, error) {
+	changes, err := s.watch(key, false, stopCh)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan *StoreKVPair)
+	go func() {
+		defer close(ch)
+
+		for range changes {
+			entry, err := s.Get(key)
+			if err == store.ErrKeyNotFound {
+				entry = &StoreKVPair{Key: key}
+			} else if err != nil {
+				s.logger.Error(err)
+				continue
+			}
+
+			select {
+			case ch <- entry:
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// WatchTree watches all keys below key through a recursive etcd watcher
+func (s *EtcdStore) WatchTree(key string, stopCh <-chan struct{}) (<-chan []*StoreKVPair, error) {
+	changes, err := s.watch(key, true, stopCh)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan []*StoreKVPair)
+	go func() {
+		defer close(ch)
+
+		for range changes {
+			entries, err := s.List(key)
+			if err == store.ErrKeyNotFound {
+				entries = []*StoreKVPair{}
+			} else if err != nil {
+				s.logger.Error(err)
+				continue
+			}
+
+			select {
+			case ch <- entries:
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// watch signals once and again on every change of the key or directory. It
+// starts at the current index of the cluster, so no change gets lost. The
+// channel is closed when stopCh is closed or the watcher fails
+func (s *EtcdStore) watch(key string, recursive bool, stopCh <-chan struct{}) (<-chan struct{}, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	resp, err := s.Client.Get(ctx, "/", nil)
+	cancel()
+
+	if err != nil {
+		return nil, err
+	}
+
+	w := s.Client.Watcher(key, &etcd.WatcherOptions{AfterIndex: resp.Index, Recursive: recursive})
+	changes := make(chan struct{}, 1)
+	changes <- struct{}{}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	go func() {
+		defer close(changes)
+		defer cancel()
+
+		for {
+			if _, err := w.Next(ctx); err != nil {
+				if ctx.Err() == nil {
+					s.logger.Error(err)
+				}
+				return
+			}
+
+			// coalesce events that arrive before the last one was handled
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return changes, nil
+}
+
+// Import writes every file below dir to the key of its relative path
+func (s *EtcdStore) Import(dir string) error {
+	return importTree(dir, s.logger, func(key string, data []byte) error {
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		defer cancel()
+
+		_, err := s.Client.Set(ctx, key, string(data), nil)
+		return err
+	})
+}
+
+// NewEtcdStore creates a new etcd v2 store
+func NewEtcdStore(c *Configuration, logger *logrus.Logger) (Store, error) {
+	timeout := time.Duration(c.Backend.Timeout) * time.Second
+
+	endpoints := []string{}
+	for _, e := range c.GetBackendEndpointList() {
+		if !strings.Contains(e, "://") {
+			e = "http://" + e
+		}
+		endpoints = append(endpoints, e)
+	}
+
+	client, err := etcd.New(etcd.Config{
+		Endpoints:               endpoints,
+		Transport:               etcd.DefaultTransport,
+		HeaderTimeoutPerRequest: timeout,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &EtcdStore{
+		Client:  etcd.NewKeysAPI(client),
+		timeout: timeout,
+		logger:  logger,
+	}, nil
+}
@@ -0,0 +1,349 @@
+package driver
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// etcd3PageSize limits the number of keys fetched by a single range request
+const etcd3PageSize = 500
+
+// Etcd3Store talks to etcd through the native v3 api
+type Etcd3Store struct {
+	Client  *clientv3.Client
+	timeout time.Duration
+	logger  *logrus.Logger
+}
+
+// Get a kv entry by key
+func (s *Etcd3Store) Get(key string) (*StoreKVPair, error) {
+	entry, _, err := s.get(key)
+	return entry, err
+}
+
+// get a kv entry by key along with the revision it was read at
+func (s *Etcd3Store) get(key string) (*StoreKVPair, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	resp, err := s.Client.Get(ctx, key)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	kvs, err := s.withoutExpiredLeases(ctx, resp.Kvs)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if len(kvs) == 0 {
+		return nil, resp.Header.Revision, store.ErrKeyNotFound
+	}
+
+	return &StoreKVPair{
+		Key:       string(kvs[0].Key),
+		Value:     kvs[0].Value,
+		LastIndex: uint64(kvs[0].ModRevision),
+	}, resp.Header.Revision, nil
+}
+
+// List kv entries by key. etcd v3 has a flat keyspace, so nested keys are
+// collapsed to folders marked by a trailing slash
+func (s *Etcd3Store) List(key string) ([]*StoreKVPair, error) {
+	entries, err := s.ListTree(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return folderChildren(key, entries), nil
+}
+
+// ListTree fetches all kv entries below key with a single range request
+// per page
+func (s *Etcd3Store) ListTree(key string) ([]*StoreKVPair, error) {
+	entries, _, err := s.listTree(key)
+	return entries, err
+}
+
+// listTree fetches all kv entries below key along with the revision they
+// were read at
+func (s *Etcd3Store) listTree(key string) ([]*StoreKVPair, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	prefix := key
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	kvs, rev, err := s.rangePrefix(ctx, prefix)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	kvs, err = s.withoutExpiredLeases(ctx, kvs)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if len(kvs) == 0 {
+		return nil, rev, store.ErrKeyNotFound
+	}
+
+	entries := []*StoreKVPair{}
+	for _, kv := range kvs {
+		entries = append(entries, &StoreKVPair{
+			Key:       string(kv.Key),
+			Value:     kv.Value,
+			LastIndex: uint64(kv.ModRevision),
+		})
+	}
+
+	return entries, rev, nil
+}
+
+// rangePrefix reads all keys with the given prefix page by page. Every page
+// after the first is pinned to the revision of the first one, so the result
+// is a consistent snapshot even if the keys change in between. The
+// revision of the snapshot is returned with the keys
+func (s *Etcd3Store) rangePrefix(ctx context.Context, prefix string) ([]*mvccpb.KeyValue, int64, error) {
+	kvs := []*mvccpb.KeyValue{}
+	end := clientv3.GetPrefixRangeEnd(prefix)
+	from := prefix
+
+	var rev int64
+	for {
+		opts := []clientv3.OpOption{
+			clientv3.WithRange(end),
+			clientv3.WithLimit(etcd3PageSize),
+			clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend),
+		}
+
+		if rev > 0 {
+			opts = append(opts, clientv3.WithRev(rev))
+		}
+
+		resp, err := s.Client.Get(ctx, from, opts...)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if rev == 0 {
+			rev = resp.Header.Revision
+		}
+
+		kvs = append(kvs, resp.Kvs...)
+
+		if !resp.More || len(resp.Kvs) == 0 {
+			break
+		}
+
+		// continue right after the last key of this page
+		from = string(resp.Kvs[len(resp.Kvs)-1].Key) + "\x00"
+	}
+
+	return kvs, rev, nil
+}
+
+// withoutExpiredLeases drops keys whose lease already ran out but that are
+// not yet revoked by the server
+func (s *Etcd3Store) withoutExpiredLeases(ctx context.Context, kvs []*mvccpb.KeyValue) ([]*mvccpb.KeyValue, error) {
+	alive := map[int64]bool{}
+	res := []*mvccpb.KeyValue{}
+
+	for _, kv := range kvs {
+		if kv.Lease == 0 {
+			res = append(res, kv)
+			continue
+		}
+
+		ok, known := alive[kv.Lease]
+		if !known {
+			ttl, err := s.Client.TimeToLive(ctx, clientv3.LeaseID(kv.Lease))
+			if err != nil {
+				return nil, err
+			}
+
+			ok = ttl.TTL > 0
+			alive[kv.Lease] = ok
+		}
+
+		if ok {
+			res = append(res, kv)
+		} else {
+			s.logger.Debugf("Skip key %s with expired lease %x", kv.Key, kv.Lease)
+		}
+	}
+
+	return res, nil
+}
+
+// watchEntry reads the entry of a watched key. Deleted keys are emitted
+// without value
+func (s *Etcd3Store) watchEntry(key string) (*StoreKVPair, int64, error) {
+	entry, rev, err := s.get(key)
+	if err == store.ErrKeyNotFound {
+		return &StoreKVPair{Key: key}, rev, nil
+	}
+
+	return entry, rev, err
+}
+
+// watchChildren reads the listing of a watched folder
+func (s *Etcd3Store) watchChildren(key string) ([]*StoreKVPair, int64, error) {
+	entries, rev, err := s.listTree(key)
+	if err == store.ErrKeyNotFound {
+		return []*StoreKVPair{}, rev, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+
+	return folderChildren(key, entries), rev, nil
+}
+
+// Watch a kv entry by key through an etcd watch. The watch starts right
+// after the revision of the first read, so no change in between gets lost
+func (s *Etcd3Store) Watch(key string, stopCh <-chan struct{}) (<-chan *StoreKVPair, error) {
+	entry, rev, err := s.watchEntry(key)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan *StoreKVPair)
+	changes := s.watch(key, false, rev, stopCh)
+
+	go func() {
+		defer close(ch)
+
+		for {
+			if entry != nil {
+				select {
+				case ch <- entry:
+				case <-stopCh:
+					return
+				}
+			}
+
+			if _, ok := <-changes; !ok {
+				return
+			}
+
+			entry, _, err = s.watchEntry(key)
+			if err != nil {
+				s.logger.Error(err)
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// WatchTree watches all keys below key through an etcd watch. The watch
+// starts right after the revision of the first listing
+func (s *Etcd3Store) WatchTree(key string, stopCh <-chan struct{}) (<-chan []*StoreKVPair, error) {
+	entries, rev, err := s.watchChildren(key)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan []*StoreKVPair)
+	changes := s.watch(key, true, rev, stopCh)
+
+	go func() {
+		defer close(ch)
+
+		for {
+			if entries != nil {
+				select {
+				case ch <- entries:
+				case <-stopCh:
+					return
+				}
+			}
+
+			if _, ok := <-changes; !ok {
+				return
+			}
+
+			entries, _, err = s.watchChildren(key)
+			if err != nil {
+				s.logger.Error(err)
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// watch signals on every change of the key or prefix after revision rev.
+// The channel is closed when stopCh is closed or the watch fails
+func (s *Etcd3Store) watch(key string, prefix bool, rev int64, stopCh <-chan struct{}) <-chan struct{} {
+	ctx, cancel := context.WithCancel(clientv3.WithRequireLeader(context.Background()))
+	changes := make(chan struct{}, 1)
+
+	opts := []clientv3.OpOption{clientv3.WithRev(rev + 1)}
+	if prefix {
+		if !strings.HasSuffix(key, "/") {
+			key += "/"
+		}
+		opts = append(opts, clientv3.WithPrefix())
+	}
+
+	wch := s.Client.Watch(ctx, key, opts...)
+
+	go func() {
+		defer close(changes)
+		defer cancel()
+
+		for {
+			select {
+			case <-stopCh:
+				return
+			case resp, ok := <-wch:
+				if !ok || resp.Err() != nil {
+					if ok {
+						s.logger.Error(resp.Err())
+					}
+					return
+				}
+
+				// coalesce events that arrive before the last one was handled
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return changes
+}
+
+// NewEtcd3Store creates a new etcd v3 store
+func NewEtcd3Store(c *Configuration, logger *logrus.Logger) (Store, error) {
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   c.GetBackendEndpointList(),
+		DialTimeout: time.Duration(c.Backend.Timeout) * time.Second,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewEtcd3StoreFromClient(client, c, logger), nil
+}
+
+// NewEtcd3StoreFromClient creates a new etcd v3 store on top of a client
+func NewEtcd3StoreFromClient(client *clientv3.Client, c *Configuration, logger *logrus.Logger) *Etcd3Store {
+	return &Etcd3Store{
+		Client:  client,
+		timeout: time.Duration(c.Backend.Timeout) * time.Second,
+		logger:  logger,
+	}
+}
@@ -0,0 +1,264 @@
+package driver_test
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	. "github.com/axelspringer/docker-conf-volume/driver"
+	"github.com/docker/libkv/store"
+	"github.com/sirupsen/logrus"
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// etcd3Mock is an in memory stand-in of the etcd v3 kv, lease and watch
+// api. Every put creates a new revision, older revisions stay readable
+type etcd3Mock struct {
+	clientv3.KV
+	clientv3.Lease
+	clientv3.Watcher
+
+	m        sync.Mutex
+	history  []map[string]*mvccpb.KeyValue
+	ttls     map[int64]int64
+	pageSize int
+	afterGet func()
+	watchers []chan clientv3.WatchResponse
+
+	// revisions requested by every range request and watch, ttl lookups
+	gets     []int64
+	watches  []int64
+	ttlCalls int
+}
+
+func newEtcd3Mock() *etcd3Mock {
+	return &etcd3Mock{
+		history:  []map[string]*mvccpb.KeyValue{},
+		ttls:     map[int64]int64{},
+		pageSize: 2,
+	}
+}
+
+// put a key with an optional lease at a new revision and notify all watches
+func (m *etcd3Mock) put(key string, value string, lease int64) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	rev := int64(len(m.history) + 1)
+	next := map[string]*mvccpb.KeyValue{}
+	if len(m.history) > 0 {
+		for k, kv := range m.history[len(m.history)-1] {
+			next[k] = kv
+		}
+	}
+
+	next[key] = &mvccpb.KeyValue{Key: []byte(key), Value: []byte(value), ModRevision: rev, Lease: lease}
+	m.history = append(m.history, next)
+
+	for _, w := range m.watchers {
+		w <- clientv3.WatchResponse{Header: pb.ResponseHeader{Revision: rev}}
+	}
+}
+
+// revisions reads the revisions requested so far
+func (m *etcd3Mock) revisions() ([]int64, []int64) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	return append([]int64{}, m.gets...), append([]int64{}, m.watches...)
+}
+
+func (m *etcd3Mock) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	op := clientv3.OpGet(key, opts...)
+
+	m.m.Lock()
+	rev := op.Rev()
+	if rev == 0 {
+		rev = int64(len(m.history))
+	}
+	m.gets = append(m.gets, op.Rev())
+
+	resp := &clientv3.GetResponse{Header: &pb.ResponseHeader{Revision: rev}}
+	snapshot := m.history[rev-1]
+
+	if end := string(op.RangeBytes()); len(end) == 0 {
+		if kv, ok := snapshot[key]; ok {
+			resp.Kvs = append(resp.Kvs, kv)
+		}
+	} else {
+		keys := []string{}
+		for k := range snapshot {
+			if k >= key && k < end {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		if len(keys) > m.pageSize {
+			keys = keys[:m.pageSize]
+			resp.More = true
+		}
+
+		for _, k := range keys {
+			resp.Kvs = append(resp.Kvs, snapshot[k])
+		}
+	}
+
+	after := m.afterGet
+	m.afterGet = nil
+	m.m.Unlock()
+
+	if after != nil {
+		after()
+	}
+
+	return resp, nil
+}
+
+func (m *etcd3Mock) TimeToLive(ctx context.Context, id clientv3.LeaseID, opts ...clientv3.LeaseOption) (*clientv3.LeaseTimeToLiveResponse, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.ttlCalls++
+	return &clientv3.LeaseTimeToLiveResponse{ID: id, TTL: m.ttls[int64(id)]}, nil
+}
+
+// Watch replays the revisions from the start revision on, like etcd does
+func (m *etcd3Mock) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	op := clientv3.OpGet(key, opts...)
+
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.watches = append(m.watches, op.Rev())
+
+	ch := make(chan clientv3.WatchResponse, 16)
+	for rev := op.Rev(); rev > 0 && rev <= int64(len(m.history)); rev++ {
+		ch <- clientv3.WatchResponse{Header: pb.ResponseHeader{Revision: rev}}
+	}
+	m.watchers = append(m.watchers, ch)
+
+	return ch
+}
+
+func (m *etcd3Mock) Close() error {
+	return nil
+}
+
+var _ = Describe("Etcd3Store", func() {
+	var (
+		m *etcd3Mock
+		s *Etcd3Store
+	)
+
+	BeforeEach(func() {
+		m = newEtcd3Mock()
+		m.put("/app/a", "1", 0)
+		m.put("/app/b", "2", 0)
+		m.put("/app/conf/c", "3", 0)
+		m.put("/app/conf/d", "4", 0)
+		m.put("/app/e", "5", 0)
+		m.put("/apple", "x", 0)
+
+		s = NewEtcd3StoreFromClient(&clientv3.Client{KV: m, Lease: m, Watcher: m}, NewConfiguration(), logrus.New())
+	})
+
+	keysOf := func(entries []*StoreKVPair) []string {
+		keys := []string{}
+		for _, e := range entries {
+			keys = append(keys, e.Key)
+		}
+
+		return keys
+	}
+
+	It("gets a key", func() {
+		entry, err := s.Get("/app/a")
+		Expect(err).To(BeNil())
+		Expect(string(entry.Value)).To(Equal("1"))
+		Expect(entry.LastIndex).To(Equal(uint64(1)))
+
+		_, err = s.Get("/app/missing")
+		Expect(err).To(Equal(store.ErrKeyNotFound))
+	})
+
+	It("pages through a folder at the revision of the first page", func() {
+		m.afterGet = func() { m.put("/app/f", "6", 0) }
+
+		entries, err := s.ListTree("/app")
+		Expect(err).To(BeNil())
+		Expect(keysOf(entries)).To(Equal([]string{"/app/a", "/app/b", "/app/conf/c", "/app/conf/d", "/app/e"}))
+
+		gets, _ := m.revisions()
+		Expect(gets).To(Equal([]int64{0, 6, 6}))
+
+		entries, err = s.ListTree("/app")
+		Expect(err).To(BeNil())
+		Expect(keysOf(entries)).To(ContainElement("/app/f"))
+	})
+
+	It("lists nested keys as folders", func() {
+		entries, err := s.List("/app")
+		Expect(err).To(BeNil())
+		Expect(keysOf(entries)).To(Equal([]string{"/app/a", "/app/b", "/app/conf/", "/app/e"}))
+		Expect(entries[2].IsDir).To(BeTrue())
+
+		_, err = s.List("/missing")
+		Expect(err).To(Equal(store.ErrKeyNotFound))
+	})
+
+	It("skips keys with expired leases", func() {
+		m.ttls[7] = -1
+		m.ttls[8] = 10
+		m.put("/lease/x", "x", 7)
+		m.put("/lease/y", "y", 8)
+		m.put("/lease/z", "z", 7)
+
+		entries, err := s.ListTree("/lease")
+		Expect(err).To(BeNil())
+		Expect(keysOf(entries)).To(Equal([]string{"/lease/y"}))
+		Expect(m.ttlCalls).To(Equal(2))
+
+		_, err = s.Get("/lease/x")
+		Expect(err).To(Equal(store.ErrKeyNotFound))
+	})
+
+	It("watches a key from the revision it was read at", func() {
+		stopCh := make(chan struct{})
+		defer close(stopCh)
+
+		// the key changes between the read and the watch
+		m.afterGet = func() { m.put("/app/a", "changed", 0) }
+
+		ch, err := s.Watch("/app/a", stopCh)
+		Expect(err).To(BeNil())
+
+		entry := <-ch
+		Expect(string(entry.Value)).To(Equal("1"))
+
+		_, watches := m.revisions()
+		Expect(watches).To(Equal([]int64{7}))
+
+		Eventually(ch).Should(Receive(WithTransform(func(e *StoreKVPair) string { return string(e.Value) }, Equal("changed"))))
+	})
+
+	It("watches a folder from the revision of its listing", func() {
+		stopCh := make(chan struct{})
+		defer close(stopCh)
+
+		ch, err := s.WatchTree("/app/conf", stopCh)
+		Expect(err).To(BeNil())
+		Expect(keysOf(<-ch)).To(Equal([]string{"/app/conf/c", "/app/conf/d"}))
+
+		_, watches := m.revisions()
+		Expect(watches).To(Equal([]int64{7}))
+
+		m.put("/app/conf/new", "7", 0)
+		Eventually(ch).Should(Receive(WithTransform(keysOf, ContainElement("/app/conf/new"))))
+	})
+})
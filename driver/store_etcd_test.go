@@ -0,0 +1,184 @@
+package driver_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	. "github.com/axelspringer/docker-conf-volume/driver"
+	"github.com/docker/libkv/store"
+	"github.com/sirupsen/logrus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// etcdMock is a minimal stand-in of the etcd v2 keys api. Directories are
+// implied by the keys of the values
+type etcdMock struct {
+	values map[string]string
+}
+
+// node builds the etcd node of a key, nil if it doesn't exist
+func (m *etcdMock) node(key string, children bool, recursive bool) map[string]interface{} {
+	if v, ok := m.values[key]; ok {
+		return map[string]interface{}{"key": key, "value": v, "modifiedIndex": 7}
+	}
+
+	prefix := strings.TrimSuffix(key, "/") + "/"
+	names := map[string]bool{}
+	for k := range m.values {
+		if strings.HasPrefix(k, prefix) {
+			names[strings.SplitN(k[len(prefix):], "/", 2)[0]] = true
+		}
+	}
+
+	if len(names) == 0 && key != "/" {
+		return nil
+	}
+
+	n := map[string]interface{}{"key": key, "dir": true, "modifiedIndex": 7}
+	if children {
+		sorted := []string{}
+		for name := range names {
+			sorted = append(sorted, name)
+		}
+		sort.Strings(sorted)
+
+		nodes := []interface{}{}
+		for _, name := range sorted {
+			nodes = append(nodes, m.node(prefix+name, recursive, recursive))
+		}
+		n["nodes"] = nodes
+	}
+
+	return n
+}
+
+func (m *etcdMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Etcd-Index", "7")
+
+	key := "/" + strings.Trim(strings.TrimPrefix(r.URL.Path, "/v2/keys"), "/")
+
+	switch r.Method {
+	case "PUT":
+		r.ParseForm()
+		m.values[key] = r.PostForm.Get("value")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{"action": "set", "node": m.node(key, false, false)})
+	case "GET":
+		n := m.node(key, true, r.URL.Query().Get("recursive") == "true")
+		if n == nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"errorCode": 100, "message": "Key not found", "cause": key, "index": 7})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"action": "get", "node": n})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+var _ = Describe("EtcdStore", func() {
+	var (
+		mock   *etcdMock
+		server *httptest.Server
+		s      Store
+	)
+
+	BeforeEach(func() {
+		mock = &etcdMock{values: map[string]string{
+			"/dev/auth/nginx/admin":     "",
+			"/dev/auth/mysql/root":      "S3cR37",
+			"/dev/nginx/conf.d/default": "listen 80;",
+		}}
+		server = httptest.NewServer(mock)
+
+		conf := NewConfiguration()
+		conf.Backend.Endpoints = server.URL
+
+		var err error
+		s, err = NewStore(conf, logrus.New())
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("gets values", func() {
+		entry, err := s.Get("dev/auth/mysql/root")
+		Expect(err).To(BeNil())
+		Expect(string(entry.Value)).To(Equal("S3cR37"))
+		Expect(entry.IsDir).To(BeFalse())
+	})
+
+	It("tells directories from empty values", func() {
+		entry, err := s.Get("dev/auth/nginx/admin")
+		Expect(err).To(BeNil())
+		Expect(entry.IsDir).To(BeFalse())
+
+		entry, err = s.Get("dev/auth/nginx")
+		Expect(err).To(BeNil())
+		Expect(entry.IsDir).To(BeTrue())
+	})
+
+	It("lists directories with a trailing slash", func() {
+		entries, err := s.List("dev/auth")
+		Expect(err).To(BeNil())
+		Expect(entries).To(HaveLen(2))
+		Expect(entries[0].Key).To(Equal("/dev/auth/mysql/"))
+		Expect(entries[0].IsDir).To(BeTrue())
+		Expect(entries[1].Key).To(Equal("/dev/auth/nginx/"))
+	})
+
+	It("fails on missing keys", func() {
+		_, err := s.Get("dev/do/not/exist")
+		Expect(err).To(Equal(store.ErrKeyNotFound))
+
+		_, err = s.List("dev/auth/mysql/root")
+		Expect(err).To(Equal(store.ErrKeyNotFound))
+	})
+
+	It("fetches a directory tree at once", func() {
+		entries, err := s.(TreeLister).ListTree("dev")
+		Expect(err).To(BeNil())
+
+		keys := map[string]string{}
+		for _, e := range entries {
+			keys[e.Key] = string(e.Value)
+		}
+
+		Expect(keys).To(Equal(map[string]string{
+			"/dev/auth/":                "",
+			"/dev/auth/mysql/":          "",
+			"/dev/auth/mysql/root":      "S3cR37",
+			"/dev/auth/nginx/":          "",
+			"/dev/auth/nginx/admin":     "",
+			"/dev/nginx/":               "",
+			"/dev/nginx/conf.d/":        "",
+			"/dev/nginx/conf.d/default": "listen 80;",
+		}))
+
+		_, err = s.(TreeLister).ListTree("dev/auth/mysql/root")
+		Expect(err).To(Equal(store.ErrKeyNotFound))
+	})
+
+	It("imports a directory tree", func() {
+		dir, err := ioutil.TempDir("", "confvol-etcd")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+
+		Expect(os.MkdirAll(filepath.Join(dir, "prod/app"), os.ModePerm)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "prod/app/env"), []byte("live"), 0644)).To(Succeed())
+
+		Expect(s.(Importer).Import(dir)).To(Succeed())
+		Expect(mock.values).To(HaveKeyWithValue("/prod/app/env", "live"))
+	})
+})
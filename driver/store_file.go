@@ -0,0 +1,135 @@
+package driver
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/sirupsen/logrus"
+)
+
+// FileStore serves keys from a local directory tree. Directories are
+// folders, files are values
+type FileStore struct {
+	Root         string
+	pollInterval time.Duration
+	logger       *logrus.Logger
+}
+
+// resolve maps a key to a path below the store root
+func (s *FileStore) resolve(key string) (string, error) {
+	p := filepath.Join(s.Root, filepath.FromSlash(key))
+
+	if p != s.Root && !strings.HasPrefix(p, s.Root+string(filepath.Separator)) {
+		return "", errors.New("Key " + key + " points outside of the store root")
+	}
+
+	return p, nil
+}
+
+// Get a kv entry by key
+func (s *FileStore) Get(key string) (*StoreKVPair, error) {
+	p, err := s.resolve(key)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return nil, store.ErrKeyNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	// folders have no value, like etcd dirs
+	if stat.IsDir() {
+		return &StoreKVPair{Key: key, LastIndex: uint64(stat.ModTime().UnixNano()), IsDir: true}, nil
+	}
+
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StoreKVPair{Key: key, Value: data, LastIndex: uint64(stat.ModTime().UnixNano())}, nil
+}
+
+// List kv entries by key. Folders are marked by a trailing slash
+func (s *FileStore) List(key string) ([]*StoreKVPair, error) {
+	p, err := s.resolve(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// files have no children
+	if stat, err := os.Stat(p); os.IsNotExist(err) || (err == nil && !stat.IsDir()) {
+		return nil, store.ErrKeyNotFound
+	}
+
+	files, err := ioutil.ReadDir(p)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := key
+	if len(prefix) > 0 && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	entries := []*StoreKVPair{}
+	for _, f := range files {
+		if f.IsDir() {
+			entries = append(entries, &StoreKVPair{
+				Key:       prefix + f.Name() + "/",
+				LastIndex: uint64(f.ModTime().UnixNano()),
+				IsDir:     true,
+			})
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(p, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, &StoreKVPair{
+			Key:       prefix + f.Name(),
+			Value:     data,
+			LastIndex: uint64(f.ModTime().UnixNano()),
+		})
+	}
+
+	return entries, nil
+}
+
+// Watch polls a key for changes
+func (s *FileStore) Watch(key string, stopCh <-chan struct{}) (<-chan *StoreKVPair, error) {
+	return pollWatch(s, key, s.pollInterval, stopCh)
+}
+
+// WatchTree polls a folder for changes
+func (s *FileStore) WatchTree(key string, stopCh <-chan struct{}) (<-chan []*StoreKVPair, error) {
+	return pollWatchTree(s, key, s.pollInterval, stopCh)
+}
+
+// NewFileStore creates a new store on top of a directory
+func NewFileStore(c *Configuration, logger *logrus.Logger) (Store, error) {
+	root, err := filepath.Abs(c.Backend.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	if stat, err := os.Stat(root); err != nil || !stat.IsDir() {
+		return nil, errors.New("backend.path " + c.Backend.Path + " is not a directory")
+	}
+
+	return &FileStore{
+		Root:         root,
+		pollInterval: time.Duration(c.Backend.PollInterval) * time.Second,
+		logger:       logger,
+	}, nil
+}
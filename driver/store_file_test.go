@@ -0,0 +1,99 @@
+package driver_test
+
+import (
+	. "github.com/axelspringer/docker-conf-volume/driver"
+	"github.com/sirupsen/logrus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func newDatatreeStore() Store {
+	conf := NewConfiguration()
+	conf.Backend.Type = "file"
+	conf.Backend.Path = "../examples/etcd_datatree"
+
+	s, err := NewStore(conf, logrus.New())
+	Expect(err).To(BeNil())
+
+	return s
+}
+
+var _ = Describe("FileStore", func() {
+
+	Context("Initialization", func() {
+		It("fails on a missing directory", func() {
+			conf := NewConfiguration()
+			conf.Backend.Type = "file"
+			conf.Backend.Path = "do/not/exist"
+
+			s, err := NewStore(conf, logrus.New())
+			Expect(s).To(BeNil())
+			Expect(err).To(MatchError("backend.path do/not/exist is not a directory"))
+		})
+
+		It("serves the datatree example", func() {
+			Expect(newDatatreeStore()).NotTo(BeNil())
+		})
+	})
+
+	Context("Get", func() {
+		It("returns file contents as value", func() {
+			entry, err := newDatatreeStore().Get("dev/auth/mysql/root")
+			Expect(err).To(BeNil())
+			Expect(entry.Key).To(Equal("dev/auth/mysql/root"))
+			Expect(string(entry.Value)).To(Equal("S3cR37"))
+		})
+
+		It("accepts keys with a leading slash", func() {
+			entry, err := newDatatreeStore().Get("/dev/auth/mysql/root")
+			Expect(err).To(BeNil())
+			Expect(string(entry.Value)).To(Equal("S3cR37"))
+		})
+
+		It("returns folders without value", func() {
+			entry, err := newDatatreeStore().Get("dev/auth/")
+			Expect(err).To(BeNil())
+			Expect(entry.Value).To(BeEmpty())
+		})
+
+		It("fails on missing keys", func() {
+			_, err := newDatatreeStore().Get("dev/do/not/exist")
+			Expect(err).To(MatchError("Key not found in store"))
+		})
+
+		It("rejects keys outside of the root", func() {
+			_, err := newDatatreeStore().Get("../../README.md")
+			Expect(err).To(MatchError("Key ../../README.md points outside of the store root"))
+		})
+	})
+
+	Context("List", func() {
+		It("lists files and folders", func() {
+			entries, err := newDatatreeStore().List("dev/nginx/etc/nginx/")
+			Expect(err).To(BeNil())
+
+			keys := []string{}
+			for _, e := range entries {
+				keys = append(keys, e.Key)
+			}
+
+			Expect(keys).To(ConsistOf("dev/nginx/etc/nginx/.htpasswd", "dev/nginx/etc/nginx/conf.d/"))
+		})
+
+		It("lists file values", func() {
+			entries, err := newDatatreeStore().List("dev/auth/nginx")
+			Expect(err).To(BeNil())
+			Expect(entries).To(HaveLen(3))
+
+			for _, e := range entries {
+				Expect(string(e.Value)).To(HavePrefix(e.Key[len("dev/auth/nginx/"):] + ":"))
+			}
+		})
+
+		It("fails on missing folders", func() {
+			_, err := newDatatreeStore().List("dev/do/not/exist/")
+			Expect(err).To(MatchError("Key not found in store"))
+		})
+	})
+})
@@ -0,0 +1,187 @@
+package driver
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/sirupsen/logrus"
+)
+
+// RevisionStore is a store that can be pinned to a fixed revision
+type RevisionStore interface {
+	Store
+	AtRevision(rev string) (Store, error)
+}
+
+// GitStore serves keys from the tree of a commit in a local git repository.
+// Trees are folders, blobs are values
+type GitStore struct {
+	Repository   *git.Repository
+	Ref          string
+	pollInterval time.Duration
+	logger       *logrus.Logger
+}
+
+// tree resolves the configured ref to the root tree of its commit. Branches
+// are resolved on every call, so new commits are picked up
+func (s *GitStore) tree() (*object.Tree, error) {
+	hash, err := s.Repository.ResolveRevision(plumbing.Revision(s.Ref))
+	if err != nil {
+		return nil, err
+	}
+
+	commit, err := s.Repository.CommitObject(*hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return commit.Tree()
+}
+
+// Get a kv entry by key
+func (s *GitStore) Get(key string) (*StoreKVPair, error) {
+	root, err := s.tree()
+	if err != nil {
+		return nil, err
+	}
+
+	p := strings.Trim(key, "/")
+	if len(p) == 0 {
+		return &StoreKVPair{Key: key, IsDir: true}, nil
+	}
+
+	entry, err := root.FindEntry(p)
+	if err == object.ErrEntryNotFound || err == object.ErrDirectoryNotFound {
+		return nil, store.ErrKeyNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	// trees have no value, like etcd dirs
+	if entry.Mode == filemode.Dir {
+		return &StoreKVPair{Key: key, IsDir: true}, nil
+	}
+
+	data, err := s.blob(entry.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StoreKVPair{Key: key, Value: data}, nil
+}
+
+// List kv entries by key. Folders are marked by a trailing slash
+func (s *GitStore) List(key string) ([]*StoreKVPair, error) {
+	root, err := s.tree()
+	if err != nil {
+		return nil, err
+	}
+
+	tree := root
+	if p := strings.Trim(key, "/"); len(p) > 0 {
+		// blobs have no children
+		entry, err := root.FindEntry(p)
+		if err == object.ErrEntryNotFound || err == object.ErrDirectoryNotFound || (err == nil && entry.Mode != filemode.Dir) {
+			return nil, store.ErrKeyNotFound
+		} else if err != nil {
+			return nil, err
+		}
+
+		tree, err = root.Tree(p)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	prefix := key
+	if len(prefix) > 0 && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	entries := []*StoreKVPair{}
+	for _, e := range tree.Entries {
+		switch e.Mode {
+		case filemode.Dir:
+			entries = append(entries, &StoreKVPair{Key: prefix + e.Name + "/", IsDir: true})
+		case filemode.Submodule:
+			s.logger.Debugf("Skip submodule %s%s", prefix, e.Name)
+		default:
+			data, err := s.blob(e.Hash)
+			if err != nil {
+				return nil, err
+			}
+
+			entries = append(entries, &StoreKVPair{Key: prefix + e.Name, Value: data})
+		}
+	}
+
+	return entries, nil
+}
+
+// blob reads the contents of a blob
+func (s *GitStore) blob(h plumbing.Hash) ([]byte, error) {
+	blob, err := s.Repository.BlobObject(h)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := blob.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
+
+// AtRevision returns a store pinned to a commit, tag or branch
+func (s *GitStore) AtRevision(rev string) (Store, error) {
+	hash, err := s.Repository.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return nil, errors.New("Unknown git revision " + rev + ": " + err.Error())
+	}
+
+	return &GitStore{
+		Repository:   s.Repository,
+		Ref:          hash.String(),
+		pollInterval: s.pollInterval,
+		logger:       s.logger,
+	}, nil
+}
+
+// Watch polls a key for changes
+func (s *GitStore) Watch(key string, stopCh <-chan struct{}) (<-chan *StoreKVPair, error) {
+	return pollWatch(s, key, s.pollInterval, stopCh)
+}
+
+// WatchTree polls a folder for changes
+func (s *GitStore) WatchTree(key string, stopCh <-chan struct{}) (<-chan []*StoreKVPair, error) {
+	return pollWatchTree(s, key, s.pollInterval, stopCh)
+}
+
+// NewGitStore opens a bare or working git repository
+func NewGitStore(c *Configuration, logger *logrus.Logger) (Store, error) {
+	repo, err := git.PlainOpen(c.Backend.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	ref := c.Backend.Ref
+	if len(ref) == 0 {
+		ref = "HEAD"
+	}
+
+	return &GitStore{
+		Repository:   repo,
+		Ref:          ref,
+		pollInterval: time.Duration(c.Backend.PollInterval) * time.Second,
+		logger:       logger,
+	}, nil
+}
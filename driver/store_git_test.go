@@ -0,0 +1,149 @@
+package driver_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/axelspringer/docker-conf-volume/driver"
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/sirupsen/logrus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// commitFiles writes the files into the worktree and commits them
+func commitFiles(repo *git.Repository, dir string, files map[string]string) {
+	wt, err := repo.Worktree()
+	Expect(err).To(BeNil())
+
+	for name, data := range files {
+		p := filepath.Join(dir, name)
+		Expect(os.MkdirAll(filepath.Dir(p), os.ModePerm)).To(Succeed())
+		Expect(ioutil.WriteFile(p, []byte(data), 0644)).To(Succeed())
+
+		_, err = wt.Add(name)
+		Expect(err).To(BeNil())
+	}
+
+	_, err = wt.Commit("update", &git.CommitOptions{
+		Author: &object.Signature{Name: "confvol", Email: "confvol@localhost", When: time.Now()},
+	})
+	Expect(err).To(BeNil())
+}
+
+var _ = Describe("GitStore", func() {
+	var (
+		dir string
+		s   Store
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "confvol-git")
+		Expect(err).To(BeNil())
+
+		repo, err := git.PlainInit(dir, false)
+		Expect(err).To(BeNil())
+
+		commitFiles(repo, dir, map[string]string{
+			"dev/nginx/site.conf":  "v1",
+			"dev/auth/nginx/user1": "user1:pw",
+		})
+
+		head, err := repo.Head()
+		Expect(err).To(BeNil())
+		_, err = repo.CreateTag("v1", head.Hash(), nil)
+		Expect(err).To(BeNil())
+
+		commitFiles(repo, dir, map[string]string{
+			"dev/nginx/site.conf": "v2",
+		})
+
+		conf := NewConfiguration()
+		conf.Backend.Type = "git"
+		conf.Backend.Path = dir
+
+		s, err = NewStore(conf, logrus.New())
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("fails on a missing repository", func() {
+		conf := NewConfiguration()
+		conf.Backend.Type = "git"
+		conf.Backend.Path = "do/not/exist"
+
+		_, err := NewStore(conf, logrus.New())
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("serves blobs of HEAD", func() {
+		entry, err := s.Get("dev/nginx/site.conf")
+		Expect(err).To(BeNil())
+		Expect(string(entry.Value)).To(Equal("v2"))
+	})
+
+	It("serves trees as folders", func() {
+		entry, err := s.Get("/dev/auth")
+		Expect(err).To(BeNil())
+		Expect(entry.Value).To(BeEmpty())
+
+		entries, err := s.List("dev/")
+		Expect(err).To(BeNil())
+		Expect(entries).To(HaveLen(2))
+		Expect(entries[0].Key).To(Equal("dev/auth/"))
+		Expect(entries[1].Key).To(Equal("dev/nginx/"))
+	})
+
+	It("fails on missing keys", func() {
+		_, err := s.Get("dev/nginx/missing.conf")
+		Expect(err).To(MatchError("Key not found in store"))
+
+		_, err = s.List("dev/missing/")
+		Expect(err).To(MatchError("Key not found in store"))
+	})
+
+	It("can be pinned to a tag", func() {
+		pinned, err := s.(RevisionStore).AtRevision("v1")
+		Expect(err).To(BeNil())
+
+		entry, err := pinned.Get("dev/nginx/site.conf")
+		Expect(err).To(BeNil())
+		Expect(string(entry.Value)).To(Equal("v1"))
+	})
+
+	It("rejects unknown revisions", func() {
+		_, err := s.(RevisionStore).AtRevision("v9")
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("mounts a volume pinned through the ref option", func() {
+		root, err := ioutil.TempDir("", "confvol-root")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(root)
+
+		conf := NewConfiguration()
+		conf.Driver.RootPath = root
+
+		cv, err := NewConfigVolume(conf, logrus.New(), s)
+		Expect(err).To(BeNil())
+
+		err = cv.Create(&volume.CreateRequest{Name: "dev/nginx/site.conf", Options: map[string]string{"ref": "v1"}})
+		Expect(err).To(BeNil())
+
+		res, err := cv.Mount(&volume.MountRequest{Name: "dev/nginx/site.conf"})
+		Expect(err).To(BeNil())
+
+		data, err := ioutil.ReadFile(res.Mountpoint)
+		Expect(err).To(BeNil())
+		Expect(string(data)).To(Equal("v1"))
+	})
+})
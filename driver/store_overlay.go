@@ -0,0 +1,157 @@
+package driver
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/sirupsen/logrus"
+)
+
+// OverlayStore combines several stores. The first layer that knows a key
+// wins, folder listings are merged across all layers. A layer that fails
+// fails the whole read, so an override is never bypassed
+type OverlayStore struct {
+	Layers       []Store
+	pollInterval time.Duration
+	logger       *logrus.Logger
+}
+
+// Get a kv entry from the first layer that has the key
+func (s *OverlayStore) Get(key string) (*StoreKVPair, error) {
+	for i, l := range s.Layers {
+		entry, err := l.Get(key)
+		if err == nil {
+			return entry, nil
+		}
+
+		if err != store.ErrKeyNotFound {
+			return nil, fmt.Errorf("Overlay layer %d failed to get %s: %s", i, key, err)
+		}
+	}
+
+	return nil, store.ErrKeyNotFound
+}
+
+// List kv entries of all layers. Children with the same name are taken from
+// the first layer that lists them
+func (s *OverlayStore) List(key string) ([]*StoreKVPair, error) {
+	entries := []*StoreKVPair{}
+	names := map[string]bool{}
+
+	for i, l := range s.Layers {
+		layerEntries, err := l.List(key)
+		if err == store.ErrKeyNotFound {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("Overlay layer %d failed to list %s: %s", i, key, err)
+		}
+
+		for _, entry := range layerEntries {
+			name := path.Base(strings.TrimSuffix(entry.Key, "/"))
+			if names[name] {
+				continue
+			}
+
+			names[name] = true
+			entries = append(entries, entry)
+		}
+	}
+
+	if len(entries) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+
+	return entries, nil
+}
+
+// ListTree merges the trees of all layers. Entries are taken from the first
+// layer that has their path, entries below a value of a former layer are
+// dropped
+func (s *OverlayStore) ListTree(key string) ([]*StoreKVPair, error) {
+	entries := []*StoreKVPair{}
+	paths := map[string]bool{}
+	values := map[string]bool{}
+
+	for i, l := range s.Layers {
+		layerEntries, err := listTree(l, key)
+		if err == store.ErrKeyNotFound {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("Overlay layer %d failed to list %s: %s", i, key, err)
+		}
+
+		for _, entry := range layerEntries {
+			rel, ok := relativeKey(key, entry.Key)
+			if !ok || paths[rel] || belowValue(rel, values) {
+				continue
+			}
+
+			paths[rel] = true
+			if !entry.IsDir {
+				values[rel] = true
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	if len(entries) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+
+	return entries, nil
+}
+
+// Purge drops the copies the layers keep of key
+func (s *OverlayStore) Purge(key string) error {
+	for _, l := range s.Layers {
+		if err := purge(l, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// belowValue tells if one of the parents of p is a value
+func belowValue(p string, values map[string]bool) bool {
+	for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+		if values[dir] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Watch polls a key for changes
+func (s *OverlayStore) Watch(key string, stopCh <-chan struct{}) (<-chan *StoreKVPair, error) {
+	return pollWatch(s, key, s.pollInterval, stopCh)
+}
+
+// WatchTree polls a folder for changes
+func (s *OverlayStore) WatchTree(key string, stopCh <-chan struct{}) (<-chan []*StoreKVPair, error) {
+	return pollWatchTree(s, key, s.pollInterval, stopCh)
+}
+
+// NewOverlayStore creates a store for every layer, ordered by precedence
+func NewOverlayStore(c *Configuration, logger *logrus.Logger) (Store, error) {
+	s := &OverlayStore{
+		Layers:       []Store{},
+		pollInterval: time.Duration(c.Backend.PollInterval) * time.Second,
+		logger:       logger,
+	}
+
+	for _, layer := range c.Backend.Layers {
+		ls, err := NewStore(c.withBackend(layer), logger)
+		if err != nil {
+			return nil, err
+		}
+
+		s.Layers = append(s.Layers, ls)
+	}
+
+	return s, nil
+}
@@ -0,0 +1,136 @@
+package driver_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/axelspringer/docker-conf-volume/driver"
+	"github.com/docker/libkv/store"
+	"github.com/sirupsen/logrus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OverlayStore", func() {
+	var (
+		dir string
+		s   Store
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "confvol-overlay")
+		Expect(err).To(BeNil())
+
+		// hotfix a single file on top of the datatree
+		hotfix := filepath.Join(dir, "dev/nginx/var/www/htdocs")
+		Expect(os.MkdirAll(hotfix, os.ModePerm)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(hotfix, "index.html"), []byte("hotfix"), 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(hotfix, "50x.html"), []byte("oops"), 0644)).To(Succeed())
+
+		conf := NewConfiguration()
+		conf.Backend.Type = "overlay"
+		conf.Backend.Layers = []BackendSettings{
+			{Type: "file", Path: dir},
+			{Type: "file", Path: "../examples/etcd_datatree"},
+		}
+
+		s, err = NewStore(conf, logrus.New())
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("fails if a layer can't be created", func() {
+		conf := NewConfiguration()
+		conf.Backend.Type = "overlay"
+		conf.Backend.Layers = []BackendSettings{{Type: "file", Path: "do/not/exist"}}
+
+		_, err := NewStore(conf, logrus.New())
+		Expect(err).To(MatchError("backend.path do/not/exist is not a directory"))
+	})
+
+	It("gets keys from the first layer that has them", func() {
+		entry, err := s.Get("dev/nginx/var/www/htdocs/index.html")
+		Expect(err).To(BeNil())
+		Expect(string(entry.Value)).To(Equal("hotfix"))
+
+		entry, err = s.Get("dev/auth/mysql/root")
+		Expect(err).To(BeNil())
+		Expect(string(entry.Value)).To(Equal("S3cR37"))
+	})
+
+	It("fails on keys missing in all layers", func() {
+		_, err := s.Get("dev/do/not/exist")
+		Expect(err).To(MatchError("Key not found in store"))
+
+		_, err = s.List("dev/do/not/exist/")
+		Expect(err).To(MatchError("Key not found in store"))
+	})
+
+	It("merges the children of all layers", func() {
+		entries, err := s.List("dev/nginx/var/www/htdocs/")
+		Expect(err).To(BeNil())
+
+		values := map[string]string{}
+		for _, e := range entries {
+			values[e.Key] = string(e.Value)
+		}
+
+		Expect(values).To(HaveLen(2))
+		Expect(values).To(HaveKeyWithValue("dev/nginx/var/www/htdocs/index.html", "hotfix"))
+		Expect(values).To(HaveKeyWithValue("dev/nginx/var/www/htdocs/50x.html", "oops"))
+
+		entries, err = s.List("dev/")
+		Expect(err).To(BeNil())
+		Expect(entries).To(HaveLen(2))
+	})
+
+	It("merges the trees of all layers", func() {
+		entries, err := s.(TreeLister).ListTree("dev/nginx/var/")
+		Expect(err).To(BeNil())
+
+		values := map[string]string{}
+		for _, e := range entries {
+			values[e.Key] = string(e.Value)
+		}
+
+		Expect(values).To(HaveKeyWithValue("dev/nginx/var/www/htdocs/index.html", "hotfix"))
+		Expect(values).To(HaveKeyWithValue("dev/nginx/var/www/htdocs/50x.html", "oops"))
+		Expect(values).To(HaveKey("dev/nginx/var/www/"))
+		Expect(values).To(HaveLen(4))
+	})
+
+	It("fails if a layer fails instead of serving the layers below", func() {
+		errDown := errors.New("connection refused")
+		top := newStoreMock(nil)
+		top.get = func(key string) (*StoreKVPair, error) { return nil, errDown }
+		top.list = func(key string) ([]*StoreKVPair, error) { return nil, errDown }
+
+		overlay := &OverlayStore{Layers: []Store{top, s.(*OverlayStore).Layers[1]}}
+
+		_, err := overlay.Get("dev/auth/mysql/root")
+		Expect(err).To(MatchError("Overlay layer 0 failed to get dev/auth/mysql/root: connection refused"))
+
+		_, err = overlay.List("dev/auth/")
+		Expect(err).To(MatchError("Overlay layer 0 failed to list dev/auth/: connection refused"))
+
+		_, err = overlay.ListTree("dev/auth/")
+		Expect(err).To(MatchError("Overlay layer 0 failed to list dev/auth/: connection refused"))
+	})
+
+	It("falls through layers that don't have the key", func() {
+		bottom := newStoreMock(nil)
+		bottom.get = func(key string) (*StoreKVPair, error) { return nil, store.ErrKeyNotFound }
+
+		overlay := &OverlayStore{Layers: []Store{s.(*OverlayStore).Layers[0], bottom}}
+
+		_, err := overlay.Get("dev/auth/mysql/root")
+		Expect(err).To(Equal(store.ErrKeyNotFound))
+	})
+})
@@ -0,0 +1,282 @@
+package driver_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	. "github.com/axelspringer/docker-conf-volume/driver"
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/docker/libkv/store"
+	"github.com/sirupsen/logrus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// consulMock is an in memory stand-in of the libkv consul client. Like
+// consul, its watch sends nothing for a key that doesn't exist
+type consulMock struct {
+	store.Store
+
+	m       sync.Mutex
+	kv      map[string][]byte
+	changed chan struct{}
+}
+
+func newConsulMock(kv map[string]string) *consulMock {
+	m := &consulMock{kv: map[string][]byte{}, changed: make(chan struct{})}
+	for k, v := range kv {
+		m.kv[k] = []byte(v)
+	}
+
+	return m
+}
+
+func (m *consulMock) Put(key string, value []byte, options *store.WriteOptions) error {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.kv[key] = value
+	close(m.changed)
+	m.changed = make(chan struct{})
+
+	return nil
+}
+
+func (m *consulMock) Get(key string) (*store.KVPair, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	v, ok := m.kv[key]
+	if !ok {
+		return nil, store.ErrKeyNotFound
+	}
+
+	return &store.KVPair{Key: key, Value: v}, nil
+}
+
+func (m *consulMock) List(prefix string) ([]*store.KVPair, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	pairs := []*store.KVPair{}
+	for k, v := range m.kv {
+		if strings.HasPrefix(k, prefix) {
+			pairs = append(pairs, &store.KVPair{Key: k, Value: v})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+
+	if len(pairs) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+
+	return pairs, nil
+}
+
+func (m *consulMock) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	ch := make(chan *store.KVPair)
+
+	go func() {
+		defer close(ch)
+		for {
+			m.m.Lock()
+			v, ok := m.kv[key]
+			changed := m.changed
+			m.m.Unlock()
+
+			if ok {
+				select {
+				case ch <- &store.KVPair{Key: key, Value: v}:
+				case <-stopCh:
+					return
+				}
+			}
+
+			select {
+			case <-changed:
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+func (m *consulMock) WatchTree(prefix string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	ch := make(chan []*store.KVPair, 1)
+
+	// consul sends the recursive listing, empty if nothing is below prefix
+	pairs, _ := m.List(prefix)
+	ch <- pairs
+
+	go func() {
+		<-stopCh
+		close(ch)
+	}()
+
+	return ch, nil
+}
+
+var _ = Describe("LibKVStore", func() {
+	var (
+		mock *consulMock
+		s    *LibKVStore
+	)
+
+	BeforeEach(func() {
+		mock = newConsulMock(map[string]string{"app/a": "1"})
+		s = NewLibKVStoreFromClient(mock, NewConfiguration(), logrus.New())
+	})
+
+	Context("Folders", func() {
+		BeforeEach(func() {
+			mock = newConsulMock(map[string]string{
+				"app/a":          "1",
+				"app/empty/":     "",
+				"app/sub/b":      "2",
+				"app/sub/deep/c": "3",
+				"apple":          "x",
+			})
+			s = NewLibKVStoreFromClient(mock, NewConfiguration(), logrus.New())
+		})
+
+		// keys maps the keys of entries to whether they are folders
+		keys := func(entries []*StoreKVPair) map[string]bool {
+			m := map[string]bool{}
+			for _, e := range entries {
+				m[e.Key] = e.IsDir
+			}
+			return m
+		}
+
+		It("takes keys with a trailing slash as folders", func() {
+			entry, err := s.Get("app/empty/")
+			Expect(err).To(BeNil())
+			Expect(entry.IsDir).To(BeTrue())
+
+			entry, err = s.Get("app/a")
+			Expect(err).To(BeNil())
+			Expect(entry.IsDir).To(BeFalse())
+		})
+
+		It("lists the direct children of a folder", func() {
+			for _, key := range []string{"app/", "app"} {
+				entries, err := s.List(key)
+				Expect(err).To(BeNil())
+				Expect(keys(entries)).To(Equal(map[string]bool{
+					"app/a":      false,
+					"app/empty/": true,
+					"app/sub/":   true,
+				}))
+			}
+
+			entries, err := s.List("app/sub/")
+			Expect(err).To(BeNil())
+			Expect(keys(entries)).To(Equal(map[string]bool{"app/sub/b": false, "app/sub/deep/": true}))
+		})
+
+		It("fails on missing folders", func() {
+			_, err := s.List("app/missing/")
+			Expect(err).To(Equal(store.ErrKeyNotFound))
+
+			_, err = s.ListTree("app/missing/")
+			Expect(err).To(Equal(store.ErrKeyNotFound))
+		})
+
+		It("watches the direct children of a folder", func() {
+			stopCh := make(chan struct{})
+			defer close(stopCh)
+
+			ch, err := s.WatchTree("app/", stopCh)
+			Expect(err).To(BeNil())
+
+			var entries []*StoreKVPair
+			Eventually(ch).Should(Receive(&entries))
+			Expect(keys(entries)).To(Equal(map[string]bool{
+				"app/a":      false,
+				"app/empty/": true,
+				"app/sub/":   true,
+			}))
+		})
+
+		It("lists the whole tree below a folder", func() {
+			entries, err := s.ListTree("app")
+			Expect(err).To(BeNil())
+			Expect(keys(entries)).To(Equal(map[string]bool{
+				"app/a":          false,
+				"app/empty/":     true,
+				"app/sub/b":      false,
+				"app/sub/deep/c": false,
+			}))
+		})
+	})
+
+	It("imports a tree without reserved names", func() {
+		dir, err := ioutil.TempDir("", "confvol-import")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+
+		for _, f := range []string{"app/a", "app/sub/b", "app/..data/c", "..rev1/d", "app/..e"} {
+			Expect(os.MkdirAll(filepath.Join(dir, filepath.Dir(f)), os.ModePerm)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, f), []byte(f), 0644)).To(Succeed())
+		}
+
+		Expect(s.Import(dir)).To(Succeed())
+
+		entries, err := s.ListTree("")
+		Expect(err).To(BeNil())
+
+		imported := []string{}
+		for _, e := range entries {
+			imported = append(imported, e.Key)
+		}
+		Expect(imported).To(ConsistOf("app/a", "app/sub/b"))
+	})
+
+	Context("Watch", func() {
+		It("reports a missing key and picks it up once created", func() {
+			stopCh := make(chan struct{})
+			defer close(stopCh)
+
+			ch, err := s.Watch("app/b", stopCh)
+			Expect(err).To(BeNil())
+
+			var entry *StoreKVPair
+			Eventually(ch).Should(Receive(&entry))
+			Expect(entry.Key).To(Equal("app/b"))
+			Expect(entry.Value).To(BeEmpty())
+
+			Expect(mock.Put("app/b", []byte("2"), nil)).To(Succeed())
+			Eventually(ch).Should(Receive(&entry))
+			Expect(string(entry.Value)).To(Equal("2"))
+		})
+
+		It("doesn't block the mount of a volume whose key is missing", func() {
+			root, err := ioutil.TempDir("", "confvol-consul")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(root)
+
+			conf := NewConfiguration()
+			conf.Driver.RootPath = root
+
+			cv, err := NewConfigVolume(conf, logrus.New(), s)
+			Expect(err).To(BeNil())
+			Expect(cv.Create(&volume.CreateRequest{Name: "app/b"})).To(Succeed())
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				cv.Mount(&volume.MountRequest{Name: "app/b", ID: "c1"})
+			}()
+
+			Eventually(done).Should(BeClosed())
+			Expect(cv.Unmount(&volume.UnmountRequest{Name: "app/b", ID: "c1"})).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,273 @@
+package driver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+)
+
+// vaultListConcurrency limits the secrets read at once by List
+const vaultListConcurrency = 8
+
+// errVaultForbidden is returned when vault rejects the token
+var errVaultForbidden = errors.New("Vault permission denied")
+
+// VaultStore reads secrets from a vault kv v2 secrets engine. Every secret
+// holds its value in a single field
+type VaultStore struct {
+	Address      string
+	Mount        string
+	Field        string
+	roleID       string
+	secretID     string
+	token        string
+	client       *http.Client
+	m            *sync.Mutex
+	pollInterval time.Duration
+	logger       *logrus.Logger
+}
+
+// vaultResponse is the subset of the vault api response we care about
+type vaultResponse struct {
+	Data struct {
+		Data     map[string]interface{} `json:"data"`
+		Metadata struct {
+			Version uint64 `json:"version"`
+		} `json:"metadata"`
+		Keys []string `json:"keys"`
+	} `json:"data"`
+	Auth struct {
+		ClientToken string `json:"client_token"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+// path builds the api path of a key below the mount
+func (s *VaultStore) path(api string, key string) string {
+	return "/v1/" + s.Mount + "/" + api + "/" + strings.Trim(key, "/")
+}
+
+// login fetches a new token through the approle auth method. The store
+// has to be locked
+func (s *VaultStore) login() error {
+	body, _ := json.Marshal(map[string]string{
+		"role_id":   s.roleID,
+		"secret_id": s.secretID,
+	})
+
+	res := &vaultResponse{}
+	if err := s.do("POST", "/v1/auth/approle/login", "", body, res); err != nil {
+		return err
+	}
+
+	if len(res.Auth.ClientToken) == 0 {
+		return errors.New("Vault approle login returned no token")
+	}
+
+	s.token = res.Auth.ClientToken
+	return nil
+}
+
+// currentToken returns the token, an approle login is done first if there
+// is none yet
+func (s *VaultStore) currentToken() (string, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if len(s.roleID) > 0 && len(s.token) == 0 {
+		if err := s.login(); err != nil {
+			return "", err
+		}
+	}
+
+	return s.token, nil
+}
+
+// renewToken logs in again unless another request already replaced the
+// rejected token in the meantime
+func (s *VaultStore) renewToken(rejected string) (string, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.token == rejected {
+		s.logger.Debug("Vault token rejected, login again")
+		if err := s.login(); err != nil {
+			return "", err
+		}
+	}
+
+	return s.token, nil
+}
+
+// request sends an authenticated request. An approle token is renewed once
+// when vault rejects it. Only the token is locked, requests run in parallel
+func (s *VaultStore) request(method string, p string, res *vaultResponse) error {
+	token, err := s.currentToken()
+	if err != nil {
+		return err
+	}
+
+	err = s.do(method, p, token, nil, res)
+	if err == errVaultForbidden && len(s.roleID) > 0 {
+		if token, err = s.renewToken(token); err != nil {
+			return err
+		}
+		err = s.do(method, p, token, nil, res)
+	}
+
+	return err
+}
+
+// do sends a single request to the vault api
+func (s *VaultStore) do(method string, p string, token string, body []byte, res *vaultResponse) error {
+	req, err := http.NewRequest(method, s.Address+p, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	if len(token) > 0 {
+		req.Header.Set("X-Vault-Token", token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return json.NewDecoder(resp.Body).Decode(res)
+	case http.StatusNotFound:
+		return store.ErrKeyNotFound
+	case http.StatusForbidden:
+		return errVaultForbidden
+	}
+
+	json.NewDecoder(resp.Body).Decode(res)
+	return fmt.Errorf("Vault responded %d on %s %s: %s", resp.StatusCode, method, p, strings.Join(res.Errors, ", "))
+}
+
+// Get a secret by key
+func (s *VaultStore) Get(key string) (*StoreKVPair, error) {
+	res := &vaultResponse{}
+	if err := s.request("GET", s.path("data", key), res); err != nil {
+		return nil, err
+	}
+
+	// deleted versions have no data
+	if res.Data.Data == nil {
+		return nil, store.ErrKeyNotFound
+	}
+
+	value, ok := res.Data.Data[s.Field]
+	if !ok {
+		return nil, errors.New("Vault secret " + key + " has no field " + s.Field)
+	}
+
+	return &StoreKVPair{
+		Key:       key,
+		Value:     []byte(fmt.Sprint(value)),
+		LastIndex: res.Data.Metadata.Version,
+	}, nil
+}
+
+// List secrets by key. Folders are marked by a trailing slash
+func (s *VaultStore) List(key string) ([]*StoreKVPair, error) {
+	res := &vaultResponse{}
+	if err := s.request("LIST", s.path("metadata", key), res); err != nil {
+		return nil, err
+	}
+
+	prefix := key
+	if len(prefix) > 0 && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	// vault has no bulk read, the values are fetched in parallel
+	entries := make([]*StoreKVPair, len(res.Data.Keys))
+	g := errgroup.Group{}
+	g.SetLimit(vaultListConcurrency)
+
+	for i, k := range res.Data.Keys {
+		if strings.HasSuffix(k, "/") {
+			entries[i] = &StoreKVPair{Key: prefix + k, IsDir: true}
+			continue
+		}
+
+		i, k := i, k
+		g.Go(func() error {
+			entry, err := s.Get(prefix + k)
+			if err == store.ErrKeyNotFound {
+				return nil
+			}
+
+			entries[i] = entry
+			return err
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	// secrets deleted in the meantime are left out
+	found := []*StoreKVPair{}
+	for _, e := range entries {
+		if e != nil {
+			found = append(found, e)
+		}
+	}
+
+	return found, nil
+}
+
+// Watch polls a key for changes
+func (s *VaultStore) Watch(key string, stopCh <-chan struct{}) (<-chan *StoreKVPair, error) {
+	return pollWatch(s, key, s.pollInterval, stopCh)
+}
+
+// WatchTree polls a folder for changes
+func (s *VaultStore) WatchTree(key string, stopCh <-chan struct{}) (<-chan []*StoreKVPair, error) {
+	return pollWatchTree(s, key, s.pollInterval, stopCh)
+}
+
+// NewVaultStore creates a new vault kv v2 store
+func NewVaultStore(c *Configuration, logger *logrus.Logger) (Store, error) {
+	addr := c.GetBackendEndpointList()[0]
+	if !strings.Contains(addr, "://") {
+		addr = "https://" + addr
+	}
+
+	mount := strings.Trim(c.Backend.Vault.Mount, "/")
+	if len(mount) == 0 {
+		mount = "secret"
+	}
+
+	field := c.Backend.Vault.Field
+	if len(field) == 0 {
+		field = "value"
+	}
+
+	return &VaultStore{
+		Address:      strings.TrimSuffix(addr, "/"),
+		Mount:        mount,
+		Field:        field,
+		roleID:       c.Backend.Vault.RoleID,
+		secretID:     c.Backend.Vault.SecretID,
+		token:        c.Backend.Vault.Token,
+		client:       &http.Client{Timeout: time.Duration(c.Backend.Timeout) * time.Second},
+		m:            &sync.Mutex{},
+		pollInterval: time.Duration(c.Backend.PollInterval) * time.Second,
+		logger:       logger,
+	}, nil
+}
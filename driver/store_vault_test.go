@@ -0,0 +1,297 @@
+package driver_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/axelspringer/docker-conf-volume/driver"
+	"github.com/sirupsen/logrus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// vaultMock is a minimal stand-in of the vault kv v2 http api. Every
+// request takes delay, the most requests served at once are counted
+type vaultMock struct {
+	secrets map[string]string
+	token   string
+	logins  int
+	delay   time.Duration
+
+	m           sync.Mutex
+	inflight    int
+	maxInflight int
+}
+
+// setToken replaces the token vault accepts
+func (m *vaultMock) setToken(token string) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.token = token
+}
+
+// stats returns the logins and the most requests served at once
+func (m *vaultMock) stats() (int, int) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	return m.logins, m.maxInflight
+}
+
+func (m *vaultMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reply := func(code int, data interface{}) {
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(data)
+	}
+
+	m.m.Lock()
+	m.inflight++
+	if m.inflight > m.maxInflight {
+		m.maxInflight = m.inflight
+	}
+	token := m.token
+	m.m.Unlock()
+
+	defer func() {
+		m.m.Lock()
+		m.inflight--
+		m.m.Unlock()
+	}()
+
+	time.Sleep(m.delay)
+
+	if r.URL.Path == "/v1/auth/approle/login" {
+		body := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&body)
+
+		if body["role_id"] != "role" || body["secret_id"] != "secret" {
+			reply(http.StatusBadRequest, map[string]interface{}{"errors": []string{"invalid role or secret ID"}})
+			return
+		}
+
+		m.m.Lock()
+		m.logins++
+		m.m.Unlock()
+
+		reply(http.StatusOK, map[string]interface{}{"auth": map[string]string{"client_token": token}})
+		return
+	}
+
+	if r.Header.Get("X-Vault-Token") != token {
+		reply(http.StatusForbidden, map[string]interface{}{"errors": []string{"permission denied"}})
+		return
+	}
+
+	switch {
+	case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/v1/kv/data/"):
+		key := strings.TrimPrefix(r.URL.Path, "/v1/kv/data/")
+		if v, ok := m.secrets[key]; ok {
+			reply(http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+				"data":     map[string]string{"value": v},
+				"metadata": map[string]int{"version": 3},
+			}})
+			return
+		}
+	case r.Method == "LIST" && strings.HasPrefix(r.URL.Path, "/v1/kv/metadata/"):
+		prefix := strings.TrimPrefix(r.URL.Path, "/v1/kv/metadata/") + "/"
+		keys := []string{}
+		seen := map[string]bool{}
+		for k := range m.secrets {
+			if !strings.HasPrefix(k, prefix) {
+				continue
+			}
+			child := strings.TrimPrefix(k, prefix)
+			if i := strings.Index(child, "/"); i >= 0 {
+				child = child[:i+1]
+			}
+			if !seen[child] {
+				seen[child] = true
+				keys = append(keys, child)
+			}
+		}
+		if len(keys) > 0 {
+			reply(http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"keys": keys}})
+			return
+		}
+	}
+
+	reply(http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+}
+
+var _ = Describe("VaultStore", func() {
+	var (
+		mock   *vaultMock
+		server *httptest.Server
+		conf   *Configuration
+	)
+
+	BeforeEach(func() {
+		mock = &vaultMock{
+			token: "s.token",
+			secrets: map[string]string{
+				"dev/auth/mysql/root":  "S3cR37",
+				"dev/auth/nginx/user1": "user1:pw1",
+				"dev/auth/nginx/user2": "user2:pw2",
+			},
+		}
+		server = httptest.NewServer(mock)
+
+		conf = NewConfiguration()
+		conf.Backend.Type = "vault"
+		conf.Backend.Endpoints = server.URL
+		conf.Backend.Vault.Mount = "kv"
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Context("Token auth", func() {
+		It("reads a secret", func() {
+			conf.Backend.Vault.Token = "s.token"
+			s, err := NewStore(conf, logrus.New())
+			Expect(err).To(BeNil())
+
+			entry, err := s.Get("/dev/auth/mysql/root")
+			Expect(err).To(BeNil())
+			Expect(string(entry.Value)).To(Equal("S3cR37"))
+			Expect(entry.LastIndex).To(Equal(uint64(3)))
+		})
+
+		It("fails with a wrong token", func() {
+			conf.Backend.Vault.Token = "s.wrong"
+			s, _ := NewStore(conf, logrus.New())
+
+			_, err := s.Get("dev/auth/mysql/root")
+			Expect(err).To(MatchError("Vault permission denied"))
+		})
+
+		It("reports missing secrets", func() {
+			conf.Backend.Vault.Token = "s.token"
+			s, _ := NewStore(conf, logrus.New())
+
+			_, err := s.Get("dev/auth/mysql/admin")
+			Expect(err).To(MatchError("Key not found in store"))
+		})
+
+		It("fails on secrets without the configured field", func() {
+			conf.Backend.Vault.Token = "s.token"
+			conf.Backend.Vault.Field = "password"
+			s, _ := NewStore(conf, logrus.New())
+
+			_, err := s.Get("dev/auth/mysql/root")
+			Expect(err).To(MatchError("Vault secret dev/auth/mysql/root has no field password"))
+		})
+
+		It("lists secrets and folders", func() {
+			conf.Backend.Vault.Token = "s.token"
+			s, _ := NewStore(conf, logrus.New())
+
+			entries, err := s.List("dev/auth/")
+			Expect(err).To(BeNil())
+			Expect(entries).To(HaveLen(2))
+
+			entries, err = s.List("dev/auth/nginx")
+			Expect(err).To(BeNil())
+
+			values := []string{}
+			for _, e := range entries {
+				values = append(values, string(e.Value))
+			}
+			Expect(values).To(ConsistOf("user1:pw1", "user2:pw2"))
+		})
+
+		It("reads the secrets of a folder in parallel", func() {
+			conf.Backend.Vault.Token = "s.token"
+			s, _ := NewStore(conf, logrus.New())
+
+			mock.delay = 50 * time.Millisecond
+			for _, k := range []string{"a", "b", "c", "d", "e", "f"} {
+				mock.secrets["dev/many/"+k] = k
+			}
+
+			entries, err := s.List("dev/many")
+			Expect(err).To(BeNil())
+			Expect(entries).To(HaveLen(6))
+
+			_, maxInflight := mock.stats()
+			Expect(maxInflight).To(BeNumerically(">", 1))
+		})
+	})
+
+	Context("AppRole auth", func() {
+		It("logs in once and reuses the token", func() {
+			conf.Backend.Vault.RoleID = "role"
+			conf.Backend.Vault.SecretID = "secret"
+			s, _ := NewStore(conf, logrus.New())
+
+			_, err := s.Get("dev/auth/mysql/root")
+			Expect(err).To(BeNil())
+			_, err = s.Get("dev/auth/nginx/user1")
+			Expect(err).To(BeNil())
+
+			logins, _ := mock.stats()
+			Expect(logins).To(Equal(1))
+		})
+
+		It("logs in again when the token expired", func() {
+			conf.Backend.Vault.RoleID = "role"
+			conf.Backend.Vault.SecretID = "secret"
+			s, _ := NewStore(conf, logrus.New())
+
+			_, err := s.Get("dev/auth/mysql/root")
+			Expect(err).To(BeNil())
+
+			mock.setToken("s.renewed")
+			entry, err := s.Get("dev/auth/mysql/root")
+			Expect(err).To(BeNil())
+			Expect(string(entry.Value)).To(Equal("S3cR37"))
+
+			logins, _ := mock.stats()
+			Expect(logins).To(Equal(2))
+		})
+
+		It("logs in again only once for concurrent requests", func() {
+			conf.Backend.Vault.RoleID = "role"
+			conf.Backend.Vault.SecretID = "secret"
+			s, _ := NewStore(conf, logrus.New())
+
+			_, err := s.Get("dev/auth/mysql/root")
+			Expect(err).To(BeNil())
+
+			mock.setToken("s.renewed")
+			mock.delay = 20 * time.Millisecond
+
+			errs := make(chan error, 5)
+			for i := 0; i < 5; i++ {
+				go func() {
+					_, err := s.Get("dev/auth/mysql/root")
+					errs <- err
+				}()
+			}
+
+			for i := 0; i < 5; i++ {
+				Expect(<-errs).To(BeNil())
+			}
+
+			logins, maxInflight := mock.stats()
+			Expect(logins).To(Equal(2))
+			Expect(maxInflight).To(BeNumerically(">", 1))
+		})
+
+		It("fails with wrong credentials", func() {
+			conf.Backend.Vault.RoleID = "role"
+			conf.Backend.Vault.SecretID = "wrong"
+			s, _ := NewStore(conf, logrus.New())
+
+			_, err := s.Get("dev/auth/mysql/root")
+			Expect(err).To(MatchError(ContainSubstring("invalid role or secret ID")))
+		})
+	})
+})
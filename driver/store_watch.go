@@ -0,0 +1,142 @@
+package driver
+
+import (
+	"crypto/sha256"
+	"sort"
+	"time"
+
+	"github.com/docker/libkv/store"
+)
+
+// pollWatch emits the entry of key once and again whenever it changes.
+// It is used by stores without native watch support. Deleted keys are
+// emitted without value
+func pollWatch(s Store, key string, interval time.Duration, stopCh <-chan struct{}) (<-chan *StoreKVPair, error) {
+	ch := make(chan *StoreKVPair)
+
+	go func() {
+		defer close(ch)
+
+		last := ""
+		first := true
+
+		for {
+			entry, err := s.Get(key)
+			fingerprint := ""
+
+			switch {
+			case err == nil:
+				fingerprint = "=" + string(entry.Value)
+			case err == store.ErrKeyNotFound:
+				entry = &StoreKVPair{Key: key}
+			default:
+				// backend not reachable, try again later
+				fingerprint = last
+			}
+
+			if first || fingerprint != last {
+				select {
+				case ch <- entry:
+				case <-stopCh:
+					return
+				}
+			}
+
+			first = false
+			last = fingerprint
+
+			select {
+			case <-stopCh:
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// pollWatchTree emits the listing of key once and again whenever anything
+// below key changes. It is used by stores without native watch support
+func pollWatchTree(s Store, key string, interval time.Duration, stopCh <-chan struct{}) (<-chan []*StoreKVPair, error) {
+	ch := make(chan []*StoreKVPair)
+
+	go func() {
+		defer close(ch)
+
+		var last []byte
+		first := true
+
+		for {
+			fingerprint, err := treeFingerprint(s, key)
+			if err != nil {
+				// backend not reachable, try again later
+				fingerprint = last
+			}
+
+			if first || string(fingerprint) != string(last) {
+				entries, err := s.List(key)
+				if err != nil {
+					entries = []*StoreKVPair{}
+				}
+
+				select {
+				case ch <- entries:
+				case <-stopCh:
+					return
+				}
+			}
+
+			first = false
+			last = fingerprint
+
+			select {
+			case <-stopCh:
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// treeFingerprint hashes all keys and values below key
+func treeFingerprint(s Store, key string) ([]byte, error) {
+	entries, err := listTree(s, key)
+	if err != nil && err != store.ErrKeyNotFound {
+		return nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+
+	h := sha256.New()
+	for _, e := range entries {
+		h.Write([]byte(e.Key))
+		h.Write([]byte{0})
+		h.Write(e.Value)
+		h.Write([]byte{0})
+	}
+
+	return h.Sum(nil), nil
+}
+
+// walkTree calls fn for every entry below key. Folders are descended into
+func walkTree(s Store, key string, fn func(*StoreKVPair)) error {
+	entries, err := s.List(key)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		fn(e)
+
+		if e.IsDir {
+			if err := walkTree(s, e.Key, fn); err != nil && err != store.ErrKeyNotFound {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
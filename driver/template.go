@@ -2,14 +2,57 @@ package driver
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"path"
+	"sort"
 	"strings"
 	"text/template"
+
+	"github.com/BurntSushi/toml"
+	"github.com/docker/libkv/store"
+	"gopkg.in/yaml.v3"
 )
 
-// ConfTemplate data struct
+// ConfTemplate data struct. Strict makes the lenient helpers StoreGet and
+// StoreList fail the render instead of resolving to empty values
 type ConfTemplate struct {
+	Strict     bool
 	store      Store
 	funcHelper template.FuncMap
+	keys       map[string]bool
+	folders    map[string]bool
+}
+
+// TemplateContext is the data templates are rendered with, so one template
+// can serve several hosts and environments, e.g.
+//
+//	{{ .Hostname }} {{ index .Env "STAGE" }} {{ index .Options "key" }}
+type TemplateContext struct {
+	// Volume is the name of the volume
+	Volume string
+	// Key is the key the volume syncs from
+	Key string
+	// Template is the key of the rendered template, below Key in folders
+	Template string
+	// Options are the options the volume was created with
+	Options map[string]string
+	// Hostname is the name of the host the plugin runs on
+	Hostname string
+	// Env holds the plugin environment variables listed in generator.env
+	Env map[string]string
+	// MountID is the ID of the latest mount of the volume
+	MountID string
+}
+
+// TemplateEntry is a child of a folder as the listing helpers see it. Name
+// is the last element of the key, Children are only filled by StoreTree
+type TemplateEntry struct {
+	Name     string
+	Key      string
+	Value    string
+	IsDir    bool
+	Children []*TemplateEntry
 }
 
 // Parse evaluates a configuration template
@@ -28,11 +71,130 @@ func (ct *ConfTemplate) Parse(t string, opt interface{}) (string, error) {
 	return execBuffer.String(), nil
 }
 
+// Dependencies returns the keys and folders the helpers read from the store
+func (ct *ConfTemplate) Dependencies() ([]string, []string) {
+	keys := []string{}
+	for k := range ct.keys {
+		keys = append(keys, k)
+	}
+
+	folders := []string{}
+	for k := range ct.folders {
+		folders = append(folders, k)
+	}
+
+	sort.Strings(keys)
+	sort.Strings(folders)
+
+	return keys, folders
+}
+
+// get reads a key for the helpers and records it as dependency. Without a
+// store no key is found
+func (ct *ConfTemplate) get(k string) (*StoreKVPair, error) {
+	if ct.store == nil {
+		return nil, store.ErrKeyNotFound
+	}
+
+	ct.keys[k] = true
+	return ct.store.Get(k)
+}
+
+// list reads the entries below a folder for the helpers and records it as
+// dependency. Failures resolve to no entries unless the template is strict
+func (ct *ConfTemplate) list(k string, fetch func(Store, string) ([]*StoreKVPair, error)) ([]*StoreKVPair, error) {
+	if ct.store == nil {
+		return nil, nil
+	}
+
+	ct.folders[k] = true
+	entries, err := fetch(ct.store, k)
+	if err != nil {
+		if ct.Strict {
+			return nil, errors.New("Failed to list " + k + ": " + err.Error())
+		}
+		return nil, nil
+	}
+
+	return entries, nil
+}
+
+// templateEntries converts the children of a folder, sorted by name
+func templateEntries(entries []*StoreKVPair) []*TemplateEntry {
+	children := []*TemplateEntry{}
+	for _, e := range entries {
+		children = append(children, &TemplateEntry{
+			Name:  path.Base(strings.TrimSuffix(e.Key, "/")),
+			Key:   e.Key,
+			Value: string(e.Value),
+			IsDir: e.IsDir,
+		})
+	}
+
+	sortTemplateEntries(children)
+	return children
+}
+
+// templateTree nests the entries of a recursive listing of k. Folders the
+// store doesn't list on their own are added
+func templateTree(k string, entries []*StoreKVPair) []*TemplateEntry {
+	root := &TemplateEntry{IsDir: true}
+	folders := map[string]*TemplateEntry{".": root}
+
+	var folder func(rel string) *TemplateEntry
+	folder = func(rel string) *TemplateEntry {
+		if f, ok := folders[rel]; ok {
+			return f
+		}
+
+		f := &TemplateEntry{Name: path.Base(rel), Key: strings.TrimSuffix(k, "/") + "/" + rel + "/", IsDir: true}
+		parent := folder(path.Dir(rel))
+		parent.Children = append(parent.Children, f)
+		folders[rel] = f
+
+		return f
+	}
+
+	for _, e := range entries {
+		rel, ok := relativeKey(k, e.Key)
+		if !ok {
+			continue
+		}
+
+		if e.IsDir {
+			folder(rel).Key = e.Key
+			continue
+		}
+
+		parent := folder(path.Dir(rel))
+		parent.Children = append(parent.Children, &TemplateEntry{Name: path.Base(rel), Key: e.Key, Value: string(e.Value)})
+	}
+
+	sortTemplateEntries(root.Children)
+	return root.Children
+}
+
+// sortTemplateEntries orders entries and their children by name
+func sortTemplateEntries(entries []*TemplateEntry) {
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	for _, e := range entries {
+		sortTemplateEntries(e.Children)
+	}
+}
+
+// getError explains why a helper failed to read a key
+func getError(k string, err error) error {
+	return errors.New("Failed to get " + k + ": " + err.Error())
+}
+
 // NewTemplate create a new configuration template
 func NewTemplate(s Store) *ConfTemplate {
 	t := &ConfTemplate{
 		store:      s,
 		funcHelper: template.FuncMap{},
+		keys:       map[string]bool{},
+		folders:    map[string]bool{},
 	}
 
 	// RemoveNewline is a helper remove trailing newlines
@@ -41,32 +203,162 @@ func NewTemplate(s Store) *ConfTemplate {
 	}
 
 	// StoreGet is a helper to fetch a value from the kv
-	t.funcHelper["StoreGet"] = func(k string) string {
-		if t.store == nil {
-			return ""
+	t.funcHelper["StoreGet"] = func(k string) (string, error) {
+		entry, err := t.get(k)
+		if err != nil {
+			if t.Strict {
+				return "", getError(k, err)
+			}
+			return "", nil
 		}
 
-		entry, err := t.store.Get(k)
+		return string(entry.Value), nil
+	}
+
+	// StoreMustGet fetches a value from the kv and fails if it can't
+	t.funcHelper["StoreMustGet"] = func(k string) (string, error) {
+		entry, err := t.get(k)
 		if err != nil {
-			return ""
+			return "", getError(k, err)
 		}
 
-		return string(entry.Value)
+		return string(entry.Value), nil
+	}
+
+	// StoreGetDefault fetches a value from the kv, fallback if the key is missing
+	t.funcHelper["StoreGetDefault"] = func(k string, fallback string) (string, error) {
+		entry, err := t.get(k)
+		switch {
+		case err == nil:
+			return string(entry.Value), nil
+		case err == store.ErrKeyNotFound || !t.Strict:
+			return fallback, nil
+		}
+
+		return "", getError(k, err)
+	}
+
+	// StoreExists tells if a key is in the kv
+	t.funcHelper["StoreExists"] = func(k string) (bool, error) {
+		_, err := t.get(k)
+		switch {
+		case err == nil:
+			return true, nil
+		case err == store.ErrKeyNotFound || !t.Strict:
+			return false, nil
+		}
+
+		return false, getError(k, err)
 	}
 
 	// StoreList is listing all
-	t.funcHelper["StoreList"] = func(k string) []string {
-		ret := []string{}
-		if t.store == nil {
-			return ret
+	t.funcHelper["StoreList"] = func(k string) ([]string, error) {
+		entryList, err := t.list(k, Store.List)
+		if err != nil {
+			return nil, err
 		}
 
-		entryList, _ := t.store.List(k)
+		ret := []string{}
 		for _, kv := range entryList {
 			ret = append(ret, string(kv.Value))
 		}
 
-		return ret
+		return ret, nil
+	}
+
+	// StoreListKeys lists the children of a folder with their names
+	t.funcHelper["StoreListKeys"] = func(k string) ([]*TemplateEntry, error) {
+		entryList, err := t.list(k, Store.List)
+		if err != nil {
+			return nil, err
+		}
+
+		return templateEntries(entryList), nil
+	}
+
+	// StoreMap maps the names of the values in a folder to the values
+	t.funcHelper["StoreMap"] = func(k string) (map[string]string, error) {
+		entryList, err := t.list(k, Store.List)
+		if err != nil {
+			return nil, err
+		}
+
+		ret := map[string]string{}
+		for _, e := range templateEntries(entryList) {
+			if !e.IsDir {
+				ret[e.Name] = e.Value
+			}
+		}
+
+		return ret, nil
+	}
+
+	// StoreTree lists a folder recursive, folders hold their children
+	t.funcHelper["StoreTree"] = func(k string) ([]*TemplateEntry, error) {
+		entryList, err := t.list(k, listTree)
+		if err != nil {
+			return nil, err
+		}
+
+		return templateTree(k, entryList), nil
+	}
+
+	// StoreGetJSON fetches a value from the kv and parses it as json
+	t.funcHelper["StoreGetJSON"] = func(k string) (interface{}, error) {
+		entry, err := t.get(k)
+		if err != nil {
+			if t.Strict {
+				return nil, getError(k, err)
+			}
+			return nil, nil
+		}
+
+		var v interface{}
+		if err := json.Unmarshal(entry.Value, &v); err != nil {
+			return nil, errors.New("Failed to parse " + k + ": " + err.Error())
+		}
+
+		return v, nil
+	}
+
+	// fromJson parses a json document
+	t.funcHelper["fromJson"] = func(s string) (interface{}, error) {
+		var v interface{}
+		err := json.Unmarshal([]byte(s), &v)
+		return v, err
+	}
+
+	// fromYaml parses a yaml document
+	t.funcHelper["fromYaml"] = func(s string) (interface{}, error) {
+		var v interface{}
+		err := yaml.Unmarshal([]byte(s), &v)
+		return v, err
+	}
+
+	// fromToml parses a toml document
+	t.funcHelper["fromToml"] = func(s string) (map[string]interface{}, error) {
+		v := map[string]interface{}{}
+		err := toml.Unmarshal([]byte(s), &v)
+		return v, err
+	}
+
+	// toJson formats a value as json
+	t.funcHelper["toJson"] = func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	}
+
+	// toYaml formats a value as yaml
+	t.funcHelper["toYaml"] = func(v interface{}) (string, error) {
+		data, err := yaml.Marshal(v)
+		return string(data), err
+	}
+
+	// toToml formats a map as toml
+	t.funcHelper["toToml"] = func(v interface{}) (string, error) {
+		var buf bytes.Buffer
+		err := toml.NewEncoder(&buf).Encode(v)
+		return buf.String(), err
 	}
 
 	return t
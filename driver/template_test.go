@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	. "github.com/axelspringer/docker-conf-volume/driver"
+	"github.com/docker/libkv/store"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -52,6 +53,42 @@ func (s *StoreMock) List(p string) ([]*StoreKVPair, error) {
 	return l, nil
 }
 
+func (s *StoreMock) Watch(p string, stopCh <-chan struct{}) (<-chan *StoreKVPair, error) {
+	return staticWatch(p, stopCh), nil
+}
+
+func (s *StoreMock) WatchTree(p string, stopCh <-chan struct{}) (<-chan []*StoreKVPair, error) {
+	return staticWatchTree(stopCh), nil
+}
+
+// staticWatch is the watch of a test store that never changes by itself. It
+// emits the initial event only and is closed with stopCh
+func staticWatch(p string, stopCh <-chan struct{}) <-chan *StoreKVPair {
+	ch := make(chan *StoreKVPair, 1)
+	ch <- &StoreKVPair{Key: p}
+
+	go func() {
+		<-stopCh
+		close(ch)
+	}()
+
+	return ch
+}
+
+// staticWatchTree is the folder watch of a test store that never changes
+// by itself
+func staticWatchTree(stopCh <-chan struct{}) <-chan []*StoreKVPair {
+	ch := make(chan []*StoreKVPair, 1)
+	ch <- []*StoreKVPair{}
+
+	go func() {
+		<-stopCh
+		close(ch)
+	}()
+
+	return ch
+}
+
 func newStoreMock(kv *map[string]string) *StoreMock {
 	var m *map[string]string = kv
 	if m == nil {
@@ -135,6 +172,77 @@ var _ = Describe("Template", func() {
 
 	})
 
+	// Strict template helper functions
+	Context("Test strict helpers", func() {
+		var sm *StoreMock
+
+		BeforeEach(func() {
+			sm = newStoreMock(&map[string]string{"/foo/empty": "", "/foo/user": "admin"})
+			sm.get = func(k string) (*StoreKVPair, error) {
+				if k == "/foo/down" {
+					return nil, errors.New("connection refused")
+				}
+				if v, ok := sm.kvMap[k]; ok {
+					return &StoreKVPair{Key: k, Value: []byte(v)}, nil
+				}
+				return nil, store.ErrKeyNotFound
+			}
+		})
+
+		It("StoreMustGet fails on missing keys", func() {
+			template := NewTemplate(sm)
+
+			output, err := template.Parse("{{StoreMustGet \"/foo/user\"}}{{StoreMustGet \"/foo/empty\"}}", nil)
+			Expect(err).To(BeNil())
+			Expect(output).Should(Equal("admin"))
+
+			_, err = template.Parse("{{StoreMustGet \"/foo/usr\"}}", nil)
+			Expect(err).To(MatchError(ContainSubstring("Failed to get /foo/usr: Key not found in store")))
+		})
+
+		It("StoreGetDefault falls back on missing keys", func() {
+			template := NewTemplate(sm)
+
+			output, err := template.Parse("{{StoreGetDefault \"/foo/user\" \"root\"}} {{StoreGetDefault \"/foo/usr\" \"root\"}} {{StoreGetDefault \"/foo/down\" \"root\"}}", nil)
+			Expect(err).To(BeNil())
+			Expect(output).Should(Equal("admin root root"))
+
+			template.Strict = true
+			_, err = template.Parse("{{StoreGetDefault \"/foo/down\" \"root\"}}", nil)
+			Expect(err).To(MatchError(ContainSubstring("Failed to get /foo/down: connection refused")))
+		})
+
+		It("StoreExists tells if a key is set", func() {
+			template := NewTemplate(sm)
+
+			output, err := template.Parse("{{StoreExists \"/foo/empty\"}} {{StoreExists \"/foo/usr\"}} {{if StoreExists \"/foo/user\"}}auth{{end}}", nil)
+			Expect(err).To(BeNil())
+			Expect(output).Should(Equal("true false auth"))
+
+			_, err = template.Parse("{{StoreExists \"/foo/down\"}}", nil)
+			Expect(err).To(BeNil())
+
+			template.Strict = true
+			_, err = template.Parse("{{StoreExists \"/foo/down\"}}", nil)
+			Expect(err).To(MatchError(ContainSubstring("Failed to get /foo/down: connection refused")))
+		})
+
+		It("makes StoreGet and StoreList strict", func() {
+			sm.list = func(k string) ([]*StoreKVPair, error) {
+				return nil, store.ErrKeyNotFound
+			}
+
+			template := NewTemplate(sm)
+			template.Strict = true
+
+			_, err := template.Parse("{{StoreGet \"/foo/usr\"}}", nil)
+			Expect(err).To(MatchError(ContainSubstring("Failed to get /foo/usr: Key not found in store")))
+
+			_, err = template.Parse("{{StoreList \"/foo/users/\"}}", nil)
+			Expect(err).To(MatchError(ContainSubstring("Failed to list /foo/users/: Key not found in store")))
+		})
+	})
+
 	// StoreGet template helper function
 	Context("Test StoreList helper", func() {
 
@@ -156,4 +264,136 @@ var _ = Describe("Template", func() {
 		})
 
 	})
+
+	// Key aware listing helpers
+	Context("Test listing helpers", func() {
+		var values map[string]string
+
+		BeforeEach(func() {
+			values = map[string]string{
+				"auth/nginx/bob":   "b",
+				"auth/nginx/alice": "a",
+				"auth/nginx/sub/x": "x",
+			}
+		})
+
+		It("StoreListKeys lists names and values", func() {
+			template := NewTemplate(&memStore{values: values})
+
+			output, err := template.Parse("{{range StoreListKeys \"auth/nginx\"}}{{.Name}}:{{.Value}}{{if .IsDir}}/{{end}};{{end}}", nil)
+			Expect(err).To(BeNil())
+			Expect(output).Should(Equal("alice:a;bob:b;sub:/;"))
+		})
+
+		It("StoreMap maps names to values", func() {
+			template := NewTemplate(&memStore{values: values})
+
+			output, err := template.Parse("{{range $user, $hash := StoreMap \"auth/nginx\"}}{{$user}}={{$hash}} {{end}}{{index (StoreMap \"auth/nginx\") \"bob\"}}", nil)
+			Expect(err).To(BeNil())
+			Expect(output).Should(Equal("alice=a bob=b b"))
+		})
+
+		It("StoreTree nests folders the same way for every store", func() {
+			tree := `{{define "t"}}{{range .}}{{.Key}}{{if .IsDir}}[{{template "t" .Children}}]{{else}}={{.Value}}{{end}} {{end}}{{end}}{{template "t" (StoreTree "auth")}}`
+			expected := "auth/nginx/[auth/nginx/alice=a auth/nginx/bob=b auth/nginx/sub/[auth/nginx/sub/x=x ] ] "
+
+			for _, s := range []Store{&memStore{values: values}, &memTreeStore{&memStore{values: values}}} {
+				template := NewTemplate(s)
+
+				output, err := template.Parse(tree, nil)
+				Expect(err).To(BeNil())
+				Expect(output).Should(Equal(expected))
+
+				_, folders := template.Dependencies()
+				Expect(folders).Should(Equal([]string{"auth"}))
+			}
+		})
+
+		It("resolves missing folders to no entries", func() {
+			template := NewTemplate(&memStore{values: values})
+
+			output, err := template.Parse("{{len (StoreListKeys \"do/not/exist\")}}{{len (StoreMap \"do/not/exist\")}}{{len (StoreTree \"do/not/exist\")}}", nil)
+			Expect(err).To(BeNil())
+			Expect(output).Should(Equal("000"))
+
+			template.Strict = true
+			_, err = template.Parse("{{StoreTree \"do/not/exist\"}}", nil)
+			Expect(err).To(MatchError(ContainSubstring("Failed to list do/not/exist: Key not found in store")))
+		})
+	})
+
+	// Structured data helpers
+	Context("Test structured data helpers", func() {
+		var template *ConfTemplate
+
+		BeforeEach(func() {
+			template = NewTemplate(newStoreMock(&map[string]string{
+				"/app/json": `{"db": {"host": "db1", "port": 5432}, "replicas": ["a", "b"]}`,
+				"/app/yaml": "b: 2\na: x\n",
+				"/app/toml": "title = \"x\"\n[db]\nport = 5432\n",
+				"/app/bad":  `{"db": `,
+			}))
+		})
+
+		It("StoreGetJSON reads nested fields", func() {
+			output, err := template.Parse(`{{ (StoreGetJSON "/app/json").db.host }}:{{ (StoreGetJSON "/app/json").db.port }} {{ index (StoreGetJSON "/app/json").replicas 1 }}`, nil)
+			Expect(err).To(BeNil())
+			Expect(output).Should(Equal("db1:5432 b"))
+		})
+
+		It("StoreGetJSON resolves missing keys to nothing", func() {
+			output, err := template.Parse(`{{ with StoreGetJSON "/app/missing" }}set{{ else }}unset{{ end }}`, nil)
+			Expect(err).To(BeNil())
+			Expect(output).Should(Equal("unset"))
+		})
+
+		It("StoreGetJSON fails on invalid json", func() {
+			_, err := template.Parse(`{{ StoreGetJSON "/app/bad" }}`, nil)
+			Expect(err).To(MatchError(ContainSubstring("Failed to parse /app/bad: unexpected end of JSON input")))
+		})
+
+		It("converts between the formats", func() {
+			output, err := template.Parse(`{{ toJson (fromYaml (StoreGet "/app/yaml")) }}`, nil)
+			Expect(err).To(BeNil())
+			Expect(output).Should(Equal(`{"a":"x","b":2}`))
+
+			output, err = template.Parse(`{{ toYaml (fromToml (StoreGet "/app/toml")) }}`, nil)
+			Expect(err).To(BeNil())
+			Expect(output).Should(Equal("db:\n    port: 5432\ntitle: x\n"))
+
+			output, err = template.Parse(`{{ toToml (fromJson "{\"name\": \"x\"}") }}`, nil)
+			Expect(err).To(BeNil())
+			Expect(output).Should(Equal("name = \"x\"\n"))
+		})
+
+		It("fails on invalid documents", func() {
+			_, err := template.Parse(`{{ fromJson "{" }}`, nil)
+			Expect(err).NotTo(BeNil())
+
+			_, err = template.Parse(`{{ fromYaml ": :" }}`, nil)
+			Expect(err).NotTo(BeNil())
+
+			_, err = template.Parse(`{{ fromToml "=" }}`, nil)
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	// Dependencies of the store helpers
+	Context("Test Dependencies", func() {
+
+		It("should record the keys and folders read by the helpers", func() {
+			sm := newStoreMock(nil)
+			sm.kvMap["/foo/bar/A"] = "A"
+
+			template := NewTemplate(sm)
+
+			_, err := template.Parse("{{StoreGet \"/foo/bar/A\"}}{{StoreGet \"/foo/missing\"}}{{StoreList \"/foo/bar/\"}}", nil)
+			Expect(err).To(BeNil())
+
+			keys, folders := template.Dependencies()
+			Expect(keys).Should(Equal([]string{"/foo/bar/A", "/foo/missing"}))
+			Expect(folders).Should(Equal([]string{"/foo/bar/"}))
+		})
+
+	})
 })
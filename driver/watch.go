@@ -0,0 +1,231 @@
+package driver
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// watchRetryDelay is the time to wait before a failed watch is set up again
+const watchRetryDelay = 5 * time.Second
+
+// watchKey is a key or folder a volume depends on
+type watchKey struct {
+	key  string
+	tree bool
+}
+
+// watchRound is a set of running watches
+type watchRound struct {
+	stopCh  chan struct{}
+	changes chan struct{}
+	broken  int32
+}
+
+// signal a change without blocking
+func (r *watchRound) signal() {
+	select {
+	case r.changes <- struct{}{}:
+	default:
+	}
+}
+
+// close stops all watches of the round
+func (r *watchRound) close() {
+	close(r.stopCh)
+}
+
+// fail marks the round as broken and signals a change after a while. The
+// next sync sets up the watches again
+func (r *watchRound) fail() {
+	select {
+	case <-time.After(watchRetryDelay):
+		atomic.StoreInt32(&r.broken, 1)
+		r.signal()
+	case <-r.stopCh:
+	}
+}
+
+// consume waits for the current state and signals every following change
+func (r *watchRound) consume(ch <-chan struct{}, ready chan<- struct{}) {
+	_, ok := <-ch
+	ready <- struct{}{}
+
+	if ok {
+		for range ch {
+			r.signal()
+		}
+	}
+
+	r.fail()
+}
+
+// watch sets up the watches of keys and waits until each of them delivered
+// the current state, so no later change gets lost
+func (v *ConfigVolume) watch(s Store, keys []watchKey) *watchRound {
+	r := &watchRound{
+		stopCh:  make(chan struct{}),
+		changes: make(chan struct{}, 1),
+	}
+
+	ready := make(chan struct{}, len(keys))
+
+	for _, wk := range keys {
+		events := make(chan struct{})
+
+		if wk.tree {
+			ch, err := s.WatchTree(wk.key, r.stopCh)
+			if err != nil {
+				v.logger.Errorf("Failed to watch %s: %s", wk.key, err)
+				close(events)
+			} else {
+				go func() {
+					defer close(events)
+					for range ch {
+						events <- struct{}{}
+					}
+				}()
+			}
+		} else {
+			ch, err := s.Watch(wk.key, r.stopCh)
+			if err != nil {
+				v.logger.Errorf("Failed to watch %s: %s", wk.key, err)
+				close(events)
+			} else {
+				go func() {
+					defer close(events)
+					for range ch {
+						events <- struct{}{}
+					}
+				}()
+			}
+		}
+
+		go r.consume(events, ready)
+	}
+
+	for range keys {
+		<-ready
+	}
+
+	return r
+}
+
+// watchKeysOf returns the keys a volume depends on before its first sync
+func watchKeysOf(vm *VolumeMount) []watchKey {
+	if len(vm.watchKeys) > 0 {
+		return vm.watchKeys
+	}
+
+	return []watchKey{{key: vm.Relative, tree: isFolderVolume(vm)}}
+}
+
+// sameWatchKeys compares two sets of watch keys
+func sameWatchKeys(a []watchKey, b []watchKey) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// startWatch keeps a mounted volume up to date until stopWatch is called.
+// It has to be called before the volume is synced, without holding the lock
+// of the volume. The watches are set up unlocked, so a slow store doesn't
+// block the volume, and dropped if another mount was faster or the volume
+// was unmounted in the meantime
+func (v *ConfigVolume) startWatch(vm *VolumeMount) {
+	vm.m.Lock()
+	if vm.stopWatch != nil {
+		vm.m.Unlock()
+		return
+	}
+	keys := watchKeysOf(vm)
+	s := v.storeOf(vm)
+	vm.m.Unlock()
+
+	round := v.watch(s, keys)
+
+	vm.m.Lock()
+	defer vm.m.Unlock()
+
+	if vm.stopWatch != nil || vm.ReferenceCounter <= 0 {
+		round.close()
+		return
+	}
+
+	vm.stopWatch = make(chan struct{})
+	go v.watchVolume(vm, vm.stopWatch, keys, round)
+}
+
+// stopWatch stops the updates of a volume. The volume has to be locked
+func (v *ConfigVolume) stopWatch(vm *VolumeMount) {
+	if vm.stopWatch != nil {
+		close(vm.stopWatch)
+		vm.stopWatch = nil
+	}
+}
+
+// watchVolume syncs the volume again whenever one of its keys changes
+func (v *ConfigVolume) watchVolume(vm *VolumeMount, stopCh chan struct{}, keys []watchKey, round *watchRound) {
+	defer func() {
+		round.close()
+	}()
+
+	for {
+		vm.m.Lock()
+		current := vm.watchKeys
+		s := v.storeOf(vm)
+		vm.m.Unlock()
+
+		// the template uses other keys now, watch them before syncing again.
+		// Volumes that were not synced yet have no keys
+		if len(current) > 0 && !sameWatchKeys(keys, current) {
+			round.close()
+			keys = current
+			round = v.watch(s, keys)
+
+			if !v.resync(vm, stopCh) {
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-stopCh:
+			return
+		case <-round.changes:
+		}
+
+		if atomic.LoadInt32(&round.broken) == 1 {
+			round.close()
+			round = v.watch(s, keys)
+		}
+
+		if !v.resync(vm, stopCh) {
+			return
+		}
+	}
+}
+
+// resync syncs the volume unless the watch was stopped in the meantime
+func (v *ConfigVolume) resync(vm *VolumeMount, stopCh chan struct{}) bool {
+	vm.syncM.Lock()
+	defer vm.syncM.Unlock()
+
+	select {
+	case <-stopCh:
+		return false
+	default:
+	}
+
+	v.logger.Infof("Update volume %s", vm.Relative)
+	v.syncMountPoint(vm)
+
+	return true
+}
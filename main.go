@@ -12,6 +12,7 @@ import (
 var (
 	configuration  *driver.Configuration
 	configFilePath string
+	importPath     string
 	debugFlag      bool
 )
 
@@ -20,6 +21,7 @@ func init() {
 	// args
 	flag.StringVar(&configFilePath, "config", "", "Path to the configuration file")
 	flag.BoolVar(&debugFlag, "debug", false, "Set debug mode")
+	flag.StringVar(&importPath, "import", "", "Import a directory tree into the backend and exit")
 	// parse
 	flag.Parse()
 }
@@ -40,6 +42,21 @@ func main() {
 		configuration.Backend.Endpoints = e
 	}
 
+	// env backend type
+	if t := os.Getenv("CONFVOL_BACKEND_TYPE"); len(t) > 0 {
+		configuration.Backend.Type = t
+	}
+
+	// env local backend path
+	if p := os.Getenv("CONFVOL_BACKEND_PATH"); len(p) > 0 {
+		configuration.Backend.Path = p
+	}
+
+	// env vault token
+	if t := os.Getenv("CONFVOL_VAULT_TOKEN"); len(t) > 0 {
+		configuration.Backend.Vault.Token = t
+	}
+
 	// env root path
 	if d := os.Getenv("CONFVOL_DEBUG"); len(d) > 0 {
 		debugFlag = true
@@ -70,6 +87,21 @@ func main() {
 		logger.Fatal(serr)
 	}
 
+	// fill the backend from a directory tree
+	if len(importPath) > 0 {
+		importer, ok := volumeStore.(driver.Importer)
+		if !ok {
+			logger.Fatalf("backend.type %s does not support imports", configuration.Backend.Type)
+		}
+
+		if err := importer.Import(importPath); err != nil {
+			logger.Fatal(err)
+		}
+
+		logger.Infof("Imported %s", importPath)
+		os.Exit(0)
+	}
+
 	// create volume driver
 	volumeDriver, verr := driver.NewConfigVolume(configuration, logger, volumeStore)
 	if verr != nil {